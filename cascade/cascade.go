@@ -0,0 +1,8 @@
+// Package cascade embeds the pigo cascade files, so binaries don't depend on them being on disk
+package cascade
+
+import _ "embed"
+
+// Facefinder is pigo's face detection cascade
+//go:embed facefinder
+var Facefinder []byte
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
+	"github.com/jbirms/ok-zoomer/core"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const imgEmbedFmt = `<html>
+<head>
+<style>
+* {
+margin: 0;
+padding: 0;
+}
+.imgbox {
+display: grid;
+height: 100%%;
+}
+.center-fit {
+max-width: 100%%;
+max-height: 100vh;
+margin: auto;
+}
+</style>
+</head>
+<body>
+<div class="imgbox">
+<img class="center-fit" src='%s'>
+</div>
+</body>
+</html>`
+
+//var globalTempDir = "/var/www/prettygood.dev/temp-images/"
+var globalTempDir = "/tmp/temp-images/"
+
+const (
+	S3Region = "us-east-1"
+	S3Bucket = "ok-zoomer-public-assets"
+)
+
+// group photos get a tour of up to this many faces
+const maxFacesToZoom = 3
+
+func UrlToUrl(ctx context.Context, sess *session.Session, opts core.Options, inputImageUrl, origPhoneNumber string) (string, error) {
+	uploader := s3manager.NewUploader(sess)
+
+	// download the image at inputImageUrl
+	randomName := uuid.New().String()
+	resp, err := http.Get(inputImageUrl)
+	if err != nil {
+		return "", fmt.Errorf("had trouble downloading image at %s: %s", inputImageUrl, err.Error())
+	}
+	defer resp.Body.Close()
+	rawImage, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("had trouble reading downloaded image: %s", err.Error())
+	}
+	// no point backing up something we can't use, and the format gives us the right file extension
+	format, err := core.SniffFormat(rawImage)
+	if err != nil {
+		return "", err
+	}
+
+	// we don't need to know where anyone took their photos
+	strippedImage, err := core.StripGPS(rawImage)
+	if err != nil {
+		log.Printf("not backing up the input image, since we couldn't strip its location: %s", err.Error())
+	} else {
+		_, err = uploader.Upload(&s3manager.UploadInput{
+			Body:                      bytes.NewReader(strippedImage),
+			Bucket:                    aws.String(S3Bucket),
+			Key:                       aws.String(fmt.Sprintf("/raw-images/%s.%s", randomName, format)),
+		})
+		if err != nil {
+			return "", fmt.Errorf("had trouble backing up input image to s3: %s", err.Error())
+		}
+	}
+
+	// run the gif-making logic on the image
+	var gifBuf bytes.Buffer
+	_, err = core.CreateGif(ctx, bytes.NewReader(rawImage), &gifBuf, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// upload the result to s3
+	result, err := uploader.Upload(&s3manager.UploadInput{
+		Body:                      &gifBuf,
+		Bucket:                    aws.String(S3Bucket),
+		Key:                       aws.String(fmt.Sprintf("/gifs/%s.gif", randomName)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("had trouble uploading the gif to s3: %s", err.Error())
+	}
+
+	// return the url to the gif object on s3
+	log.Printf("message from %s generated %s", origPhoneNumber, result.Location)
+	return result.Location, nil
+
+}
+
+func getUploadHandler(detector *core.FaceDetector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uploadFile(detector, w, r)
+	}
+}
+
+func uploadFile(detector *core.FaceDetector, w http.ResponseWriter, r *http.Request) {
+	fmt.Println("File Upload Endpoint Hit")
+
+	// Parse our multipart form, 10 << 20 specifies a maximum
+	// upload of 10 MB files.
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		fmt.Println("Error parsing multipart form")
+		fmt.Println(err)
+		return
+	}
+
+	// FormFile returns the first file for the given key `myFile`
+	// it also returns the FileHeader so we can get the Filename,
+	// the Header and the size of the file
+	file, handler, err := r.FormFile("myFile")
+	if err != nil {
+		fmt.Println("Error Retrieving the File")
+		fmt.Println(err)
+		return
+	}
+	defer file.Close()
+
+	fmt.Printf("Uploaded File: %+v\n", handler.Filename)
+	fmt.Printf("File Size: %+v\n", handler.Size)
+	fmt.Printf("MIME Header: %+v\n", handler.Header)
+
+	// any of the option fields in the form override our defaults
+	opts := gifOptions(detector)
+	opts.NumFrames = 20
+	opts.Fallback = core.FallbackSaliency
+	if err := applyOptionParams(&opts, r.Form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// debug=true sends back the detections drawn over the image instead of the gif
+	if debug, _ := strconv.ParseBool(r.FormValue("debug")); debug {
+		debugFile(w, r, file, opts)
+		return
+	}
+
+	// Create a temporary file within our temp-images directory that follows
+	// a particular naming pattern
+	outFile, err := ioutil.TempFile(globalTempDir, "upload-*_zoom.gif")
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "had trouble creating the gif", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	// create the dang gif
+	_, err = core.CreateGif(r.Context(), file, outFile, opts)
+	var unsupported *core.UnsupportedFormatError
+	if errors.As(err, &unsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// return that we have successfully uploaded our file!
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	fmt.Fprintf(w, imgEmbedFmt, outFile.Name())
+}
+
+// debugFile responds with core.CreateDebugPNG, for seeing why a zoom went into the wrong face
+func debugFile(w http.ResponseWriter, r *http.Request, file io.Reader, opts core.Options) {
+	var buf bytes.Buffer
+	err := core.CreateDebugPNG(r.Context(), file, &buf, opts)
+	var unsupported *core.UnsupportedFormatError
+	if errors.As(err, &unsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("had trouble drawing the debug overlay: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	buf.WriteTo(w)
+}
+
+func getPlanHandler(detector *core.FaceDetector) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		planFile(detector, w, r)
+	}
+}
+
+// planFile takes the same form as uploadFile, but only plans the gif and responds with the faces found
+// and the crop rect of every frame as JSON, so a zoom can be previewed before it's rendered
+func planFile(detector *core.FaceDetector, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "had trouble parsing the form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("myFile")
+	if err != nil {
+		http.Error(w, "had trouble reading myFile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// the same defaults as uploadFile, so the plan matches the gif it would make
+	opts := gifOptions(detector)
+	opts.NumFrames = 20
+	opts.Fallback = core.FallbackSaliency
+	if err := applyOptionParams(&opts, r.Form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preview, err := core.PlanGif(r.Context(), file, opts)
+	var unsupported *core.UnsupportedFormatError
+	if errors.As(err, &unsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("had trouble planning the gif: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		log.Printf("had trouble writing the plan: %s", err.Error())
+	}
+}
+
+// loadFaceDetector reads the cascade at CASCADE_PATH if it's set, otherwise it uses the embedded copy.
+// If PUPLOC_CASCADE_PATH is set, it also loads that cascade so zooms can be centered on the eyes.
+func loadFaceDetector() (*core.FaceDetector, error) {
+	var detector *core.FaceDetector
+	var err error
+	if cascadePath, ok := os.LookupEnv("CASCADE_PATH"); ok {
+		detector, err = core.NewFaceDetectorFromFile(cascadePath)
+	} else {
+		detector, err = core.NewDefaultFaceDetector()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if puplocPath, ok := os.LookupEnv("PUPLOC_CASCADE_PATH"); ok {
+		err = detector.AddPuplocCascadeFromFile(puplocPath)
+	} else {
+		log.Printf("PUPLOC_CASCADE_PATH isn't set, so zooms will be centered on faces rather than eyes")
+	}
+	return detector, err
+}
+
+// gifOptions scans phone photos for tilted and sideways faces, centers on the eyes if it can,
+// and gives group photos a tour of up to maxFacesToZoom faces
+func gifOptions(detector *core.FaceDetector) core.Options {
+	opts := core.DefaultOptions()
+	opts.Detector = detector
+	opts.Detection = core.MultiAngleDetectionOptions()
+	opts.Detection.LocatePupils = true
+	opts.NumFaces = maxFacesToZoom
+	return opts
+}
+
+func setupRoutes() {
+	awsSess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(S3Region),
+	}))
+	detector, err := loadFaceDetector()
+	if err != nil {
+		log.Fatalf("had trouble loading the face detector: %s", err.Error())
+	}
+	http.HandleFunc("/sms", GetTwilioHandler(awsSess, detector))
+	http.HandleFunc("/upload", getUploadHandler(detector))
+	http.HandleFunc("/plan", getPlanHandler(detector))
+	//http.Handle("/temp-images/", http.StripPrefix("/temp-images/", http.FileServer(http.Dir("temp-images"))))
+	//http.Handle(globalTempDir,
+	//	http.StripPrefix(globalTempDir,
+	//		http.FileServer(http.Dir(globalTempDir))))
+	http.ListenAndServe(":8080", nil)
+	fmt.Println("successfully set up routes!")
+}
+
+//func removeAndLog(pathToRemove string) {
+//	err := os.RemoveAll(pathToRemove)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Println("successfully removed " + pathToRemove)
+//}
+//
+func main() {
+	fmt.Println("Hello World")
+	//curdir, err := os.Getwd()
+	//if err != nil {
+	//	log.Fatal(err)
+	//}
+	//var globalTempDirAbsPath = ""
+	//globalTempDirAbsPath, err = ioutil.TempDir(curdir, "example")
+	//if err != nil {
+	//	log.Fatal(err)
+	//}
+	//defer removeAndLog(globalTempDirAbsPath)
+
+	setupRoutes()
+}
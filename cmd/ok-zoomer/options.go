@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jbirms/ok-zoomer/core"
+	"image"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// examples of every option, and of each way to pick a target, which are tested to parse
+const (
+	optionParamsExample = "frames=20 faces=2 delay=5 hold_first=50 hold_last=50 loop=0 " +
+		"max_width=480 max_height=480 score=largest easing=ease-in resample=lanczos " +
+		"colors=16 dither=bayer8 local_palettes=true max_bytes=1000000"
+	pointParamsExample = "point=120,80 zoom=3"
+	rectParamsExample  = "rect=40,20,200,140"
+)
+
+// optionParamsHelp is texted back to users who send a bad option
+const optionParamsHelp = "options look like " + optionParamsExample + ", and " + pointParamsExample + " or " +
+	rectParamsExample + " zooms in on those pixels instead of a face"
+
+// how far in a point target zooms when it's sent without a zoom
+const defaultTargetZoom = 4
+
+// applyOptionParams overrides opts with whichever of these params are set:
+// frames, faces, delay, hold_first, hold_last, loop, max_width, max_height, score, easing, resample,
+// colors, dither, local_palettes and max_bytes. A target to zoom into instead of a face is either
+// rect=x0,y0,x1,y1 or point=x,y along with an optional zoom.
+func applyOptionParams(opts *core.Options, params url.Values) error {
+	intParams := []struct {
+		name string
+		dst  *int
+	}{
+		{"frames", &opts.NumFrames},
+		{"faces", &opts.NumFaces},
+		{"delay", &opts.Delay},
+		{"hold_first", &opts.HoldFirst},
+		{"hold_last", &opts.HoldLast},
+		{"loop", &opts.LoopCount},
+		{"max_width", &opts.MaxWidth},
+		{"max_height", &opts.MaxHeight},
+		{"colors", &opts.PaletteSize},
+		{"max_bytes", &opts.MaxBytes},
+	}
+	for _, param := range intParams {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s should be a whole number, got %q", param.name, value)
+		}
+		*param.dst = n
+	}
+	// picks which face wins, e.g. the largest or the most confident one
+	if scorerName := params.Get("score"); scorerName != "" {
+		scorer, err := core.FaceScorerByName(scorerName)
+		if err != nil {
+			return err
+		}
+		opts.Detection.Scorer = scorer
+	}
+	// ease-in makes for a classic dramatic zoom
+	if easingName := params.Get("easing"); easingName != "" {
+		easing, err := core.EasingByName(easingName)
+		if err != nil {
+			return err
+		}
+		opts.Easing = easing
+	}
+	// how zoomed in frames are scaled up, from blocky nearest to sharp lanczos
+	if resamplerName := params.Get("resample"); resamplerName != "" {
+		resampler, err := core.ResamplerByName(resamplerName)
+		if err != nil {
+			return err
+		}
+		opts.Resampler = resampler
+	}
+	// bayer4 and bayer8 don't shimmer between frames like the error diffusion ditherers
+	if ditherName := params.Get("dither"); ditherName != "" {
+		ditherer, err := core.DitherByName(ditherName)
+		if err != nil {
+			return err
+		}
+		opts.Ditherer = ditherer
+	}
+	if localPalettes := params.Get("local_palettes"); localPalettes != "" {
+		b, err := strconv.ParseBool(localPalettes)
+		if err != nil {
+			return fmt.Errorf("local_palettes should be true or false, got %q", localPalettes)
+		}
+		opts.LocalPalettes = b
+	}
+	target, err := targetParams(params)
+	if err != nil {
+		return err
+	}
+	if target != nil {
+		opts.Target = target
+	}
+	return opts.Validate()
+}
+
+// targetParams reads the rect, point and zoom params, returning nil if none of them are set.
+// Whether the target is inside the image is left to core.CreateGif.
+func targetParams(params url.Values) (*core.Target, error) {
+	rect, point, zoom := params.Get("rect"), params.Get("point"), params.Get("zoom")
+	switch {
+	case rect != "" && point != "":
+		return nil, fmt.Errorf("zoom into a rect or a point, not both")
+	case rect != "":
+		coords, err := parseCoords("rect", rect, 4)
+		if err != nil {
+			return nil, err
+		}
+		return &core.Target{Rect: image.Rect(coords[0], coords[1], coords[2], coords[3])}, nil
+	case point != "":
+		coords, err := parseCoords("point", point, 2)
+		if err != nil {
+			return nil, err
+		}
+		target := &core.Target{Point: image.Pt(coords[0], coords[1]), Zoom: defaultTargetZoom}
+		if zoom != "" {
+			target.Zoom, err = strconv.ParseFloat(zoom, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zoom should be a number, got %q", zoom)
+			}
+		}
+		return target, nil
+	case zoom != "":
+		return nil, fmt.Errorf("zoom needs a point to zoom into")
+	}
+	return nil, nil
+}
+
+// parseCoords splits a comma separated list of n whole numbers, like 120,80
+func parseCoords(name, value string, n int) ([]int, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%s should be %v whole numbers separated by commas, got %q", name, n, value)
+	}
+	coords := make([]int, n)
+	for i, part := range parts {
+		coord, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("%s should be %v whole numbers separated by commas, got %q", name, n, value)
+		}
+		coords[i] = coord
+	}
+	return coords, nil
+}
+
+// parseSMSParams picks the key=value pairs out of a text message body, ignoring any other words. Even an x,y
+// pair needs to be point=x,y, since people write things like 1,000 without meaning a point.
+func parseSMSParams(body string) url.Values {
+	params := url.Values{}
+	for _, word := range strings.Fields(body) {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		params.Set(strings.ToLower(kv[0]), kv[1])
+	}
+	return params
+}
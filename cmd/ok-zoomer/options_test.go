@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/jbirms/ok-zoomer/core"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"net/url"
+	"testing"
+)
+
+func TestApplyOptionParams(t *testing.T) {
+	t.Run("overrides only what's set", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		err := applyOptionParams(&opts, url.Values{"frames": {"20"}, "loop": {"-1"}, "max_width": {"480"}})
+		assert.Nil(t, err)
+		assert.Equal(t, 20, opts.NumFrames)
+		assert.Equal(t, -1, opts.LoopCount)
+		assert.Equal(t, 480, opts.MaxWidth)
+		assert.Equal(t, core.DefaultOptions().Delay, opts.Delay)
+	})
+
+	t.Run("not a number", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"delay": {"slow"}}))
+	})
+
+	t.Run("fails validation", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"frames": {"100000"}}))
+	})
+
+	t.Run("unknown scorer", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"score": {"cutest"}}))
+	})
+
+	t.Run("easing", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"easing": {"ease-in"}}))
+		assert.NotNil(t, opts.Easing)
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"easing": {"wobbly"}}))
+	})
+
+	t.Run("resample", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"resample": {"lanczos"}}))
+		assert.Equal(t, core.Lanczos3, opts.Resampler)
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"resample": {"blocky"}}))
+	})
+
+	t.Run("colors and dither", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"colors": {"16"}, "dither": {"bayer8"}}))
+		assert.Equal(t, 16, opts.PaletteSize)
+		assert.NotNil(t, opts.Ditherer)
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"colors": {"1"}}))
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"dither": {"sparkly"}}))
+	})
+
+	t.Run("local palettes", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"local_palettes": {"true"}}))
+		assert.True(t, opts.LocalPalettes)
+		assert.NotNil(t, applyOptionParams(&opts, url.Values{"local_palettes": {"sure"}}))
+	})
+
+	t.Run("target", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"rect": {"10,20,110,70"}}))
+		assert.Equal(t, &core.Target{Rect: image.Rect(10, 20, 110, 70)}, opts.Target)
+
+		opts = core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"point": {"120,80"}, "zoom": {"2.5"}}))
+		assert.Equal(t, &core.Target{Point: image.Pt(120, 80), Zoom: 2.5}, opts.Target)
+
+		opts = core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, url.Values{"point": {"120,80"}}))
+		assert.Equal(t, float64(defaultTargetZoom), opts.Target.Zoom)
+
+		for _, params := range []url.Values{
+			{"rect": {"10,20,110"}},
+			{"point": {"120,eighty"}},
+			{"point": {"120,80"}, "zoom": {"0.5"}},
+			{"zoom": {"2"}},
+			{"point": {"120,80"}, "rect": {"10,20,110,70"}},
+		} {
+			opts := core.DefaultOptions()
+			assert.NotNil(t, applyOptionParams(&opts, params), "%v", params)
+		}
+	})
+}
+
+func TestParseSMSParams(t *testing.T) {
+	got := parseSMSParams("zoom on my cat please Frames=12  delay=3 =oops loop=")
+	assert.Equal(t, url.Values{"frames": {"12"}, "delay": {"3"}, "loop": {""}}, got)
+
+	got = parseSMSParams("the dog at point=120,80 zoom=3 not 1,2,3")
+	assert.Equal(t, url.Values{"point": {"120,80"}, "zoom": {"3"}}, got)
+
+	// numbers with commas in them aren't points
+	got = parseSMSParams("1,000 thanks for this")
+	assert.Equal(t, url.Values{}, got)
+}
+
+func TestOptionParamsHelp(t *testing.T) {
+	// the examples texted back to users had better work when they send them
+	for _, example := range []string{optionParamsExample, pointParamsExample, rectParamsExample} {
+		opts := core.DefaultOptions()
+		assert.Nil(t, applyOptionParams(&opts, parseSMSParams(example)), example)
+	}
+	assert.Contains(t, optionParamsHelp, pointParamsExample)
+
+	opts := core.DefaultOptions()
+	assert.Nil(t, applyOptionParams(&opts, parseSMSParams(pointParamsExample)))
+	assert.Equal(t, &core.Target{Point: image.Pt(120, 80), Zoom: 3}, opts.Target)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/jbirms/ok-zoomer/core"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TwilioClient struct {
+	AccountId 	string
+	APIKey 		string
+	PhoneNumber	string
+}
+
+// twilio won't send MMS media bigger than this
+const mmsMaxBytes = 5 << 20
+
+// how long a gif texted to us can take to make before we give up on it
+const smsGifTimeout = 2 * time.Minute
+
+func LoadTwilioConfigFromEnv() (TwilioClient, error) {
+	//
+	acctId, ok := os.LookupEnv("TWILIO_ACCT_ID")
+	if !ok {
+		acctId = "AC6711b0d93c5edbcd070cba8e377ac1b4" // jbirms default value
+	}
+	apiKey, ok := os.LookupEnv("TWILIO_API_KEY")
+	if !ok {
+		return TwilioClient{}, fmt.Errorf("missing TWILIO_API_KEY")
+	}
+	phoneNumber, ok := os.LookupEnv("TWILIO_PHONE_NUMBER")
+	if !ok {
+		return TwilioClient{}, fmt.Errorf("missing TWILIO_PHONE_NUMBER")
+	}
+	return TwilioClient{acctId, apiKey, phoneNumber}, nil
+}
+
+func basicAuth(username, password string) string {
+	auth := username + ":" + password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+}
+
+func (tw *TwilioClient) SendMessage(toNumber, messageText string) error {
+	return tw.send(toNumber, messageText, "")
+}
+
+// SendMedia texts messageText along with the image at mediaUrl as an MMS
+func (tw *TwilioClient) SendMedia(toNumber, messageText, mediaUrl string) error {
+	return tw.send(toNumber, messageText, mediaUrl)
+}
+
+func (tw *TwilioClient) send(toNumber, messageText, mediaUrl string) error {
+	sendUrl := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json",
+		tw.AccountId)
+	data := url.Values{}
+	data.Set("Body", messageText)
+	data.Set("From", tw.PhoneNumber)
+	data.Set("To", toNumber)
+	if mediaUrl != "" {
+		data.Set("MediaUrl", mediaUrl)
+	}
+
+	client := &http.Client{}
+	r, err := http.NewRequest("POST", sendUrl, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("had an issue posting to twilio: %s", err.Error())
+	}
+	r.Header.Add("Authorization", basicAuth(tw.AccountId, tw.APIKey))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+
+	resp, err := client.Do(r)
+	if err != nil {
+		log.Printf("had an issue making the request to twilio, %s", err.Error())
+	} else if resp.StatusCode > 299 {
+		log.Printf("got a non-200-level response code: %v", resp.StatusCode)
+	}
+	log.Println("successfully posted to twilio!")
+	return nil
+}
+
+func GetTwilioHandler(sess *session.Session, detector *core.FaceDetector) func(w http.ResponseWriter, req *http.Request) {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		twilioClient, err := LoadTwilioConfigFromEnv()
+		if err != nil {
+			log.Printf("had trouble loading the twilio config: %s", err.Error())
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		numMedia, err := strconv.Atoi(req.FormValue("NumMedia"))
+		if err != nil {
+			log.Printf("got a bad NumMedia value: %s", err.Error())
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fromNumber := req.FormValue("From")
+		if err := req.ParseForm(); err != nil {
+			fmt.Fprintf(rw, "ParseForm() err: %v", err)
+			return
+		}
+		log.Printf("received a message from %s, %s. NumMedia: %v, Mime Type: %s",
+			req.Form.Get("FromCity"), req.Form.Get("FromState"),
+			numMedia,
+			req.Form.Get("MediaContentType0"))
+
+		rw.WriteHeader(200)
+		if numMedia == 0 {
+			log.Println("Got no media in this message, sending error reply")
+			err = twilioClient.SendMessage(fromNumber, "You didn't send any media with your previous message!")
+			if err != nil {
+				log.Printf("hit an error trying to text a reply: %s", err.Error())
+			}
+			return
+		} else {
+			if numMedia > 1 {
+				// warn about us only handling the first message
+				err = twilioClient.SendMessage(fromNumber, "You sent multiple pieces of media, only handling the first one!")
+			}
+			// no fallback here, if there's no face we'd rather text the user than send them a zoom into nothing
+			opts := gifOptions(detector)
+			opts.Fallback = core.FallbackNone
+			// small enough to come through as a picture rather than just a link
+			opts.MaxBytes = mmsMaxBytes
+			if err := applyOptionParams(&opts, parseSMSParams(req.FormValue("Body"))); err != nil {
+				log.Printf("got bad options in the message body: %s", err.Error())
+				twilioClient.SendMessage(fromNumber, "Couldn't use your options: " + err.Error() + ". " + optionParamsHelp)
+				return
+			}
+			// twilio hangs up on the webhook after 15s, which a big photo can take longer than. The reply goes out as
+			// its own message anyway, so the gif is made after the handler has returned and twilio has its 200.
+			go textGif(sess, opts, twilioClient, req.FormValue("MediaUrl0"), fromNumber)
+		}
+
+	}
+}
+
+// textGif makes a gif out of the image at dataUrl and texts it back to fromNumber, or texts them why it couldn't.
+// It runs after the webhook has been answered, so it recovers its own panics rather than take down the server.
+func textGif(sess *session.Session, opts core.Options, twilioClient TwilioClient, dataUrl, fromNumber string) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panicked while texting a gif: %v", p)
+			twilioClient.SendMessage(fromNumber, "Something went wrong making your gif, sorry! Try again in a bit.")
+		}
+	}()
+	// not req.Context(), which is done as soon as the webhook has been answered
+	ctx, cancel := context.WithTimeout(context.Background(), smsGifTimeout)
+	defer cancel()
+	gifUrl, err := UrlToUrl(ctx, sess, opts, dataUrl, fromNumber)
+	if errors.Is(err, core.ErrNoFaceFound) {
+		log.Println("Found no faces in the image, sending error reply")
+		twilioClient.SendMessage(fromNumber, "We couldn't find a face in that picture! Try one where someone is looking at the camera.")
+		return
+	}
+	var unsupported *core.UnsupportedFormatError
+	if errors.As(err, &unsupported) {
+		log.Printf("got an unsupported %s image", unsupported.Format)
+		twilioClient.SendMessage(fromNumber, fmt.Sprintf("We can't make gifs out of %s files, sorry! Try a JPEG, PNG, WebP, GIF, BMP or TIFF.", unsupported.Format))
+		return
+	}
+	if errors.Is(err, core.ErrTooManyPixels) {
+		log.Printf("got an image too big to decode: %s", err.Error())
+		twilioClient.SendMessage(fromNumber, "That picture is too big for us, sorry! Try a smaller one.")
+		return
+	}
+	if errors.Is(err, core.ErrTargetOutOfBounds) {
+		log.Printf("got a zoom target outside the image: %s", err.Error())
+		twilioClient.SendMessage(fromNumber, "Couldn't zoom in there: " + err.Error() + ". Pixels count from the top left corner.")
+		return
+	}
+	if errors.Is(err, core.ErrOverBudget) {
+		log.Printf("couldn't shrink the gif enough: %s", err.Error())
+		twilioClient.SendMessage(fromNumber, "Your gif came out too big to text, even shrunk down! Try a smaller picture.")
+		return
+	}
+	if err != nil {
+		log.Printf("had trouble generating the url: %s", err.Error())
+		twilioClient.SendMessage(fromNumber, "Something went wrong making your gif, sorry! Try again in a bit.")
+		return
+	}
+	twilioClient.SendMedia(fromNumber, "here's your gif: " + gifUrl, gifUrl)
+}
@@ -0,0 +1,95 @@
+// shrinks gifs to fit in a byte budget
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ErrOverBudget is returned when a gif can't be made to fit in Options.MaxBytes
+var ErrOverBudget = errors.New("couldn't fit the gif in the byte budget")
+
+// the furthest we'll turn each knob to fit a gif in its budget
+const (
+	minBudgetSide        = 64
+	minBudgetFrames      = 8
+	minBudgetPaletteSize = 16
+)
+
+// budgetShrinker turns the knobs that make a gif smaller, a notch at a time and one after the other,
+// so no single knob takes all the damage
+type budgetShrinker struct {
+	next int
+	// how many frames the input has, which every segment plays through however few frames it's asked for
+	numSourceFrames int
+}
+
+// shrink turns the next knob that has room to go, describing what it did, or returns false when they're all
+// turned as far as they go. Dithering goes first since its noise is the hardest thing for gif compression to squash.
+func (shrinker *budgetShrinker) shrink(opts *Options, outBounds image.Rectangle) (string, bool) {
+	knobs := []func(opts *Options, outBounds image.Rectangle) (string, bool){
+		shrinkDither,
+		shrinkSize,
+		shrinkPalette,
+		shrinker.shrinkFrames,
+	}
+	for tries := 0; tries < len(knobs); tries++ {
+		knob := knobs[shrinker.next%len(knobs)]
+		shrinker.next++
+		if adjustment, ok := knob(opts, outBounds); ok {
+			return adjustment, true
+		}
+	}
+	return "", false
+}
+
+func shrinkDither(opts *Options, _ image.Rectangle) (string, bool) {
+	if opts.Ditherer == DitherNone {
+		return "", false
+	}
+	opts.Ditherer = DitherNone
+	return "turned off dithering", true
+}
+
+func shrinkSize(opts *Options, outBounds image.Rectangle) (string, bool) {
+	width, height := outBounds.Dx()*3/4, outBounds.Dy()*3/4
+	if width < minBudgetSide || height < minBudgetSide {
+		return "", false
+	}
+	opts.MaxWidth, opts.MaxHeight = width, height
+	return fmt.Sprintf("scaled down to %vx%v", width, height), true
+}
+
+func shrinkPalette(opts *Options, _ image.Rectangle) (string, bool) {
+	if opts.PaletteSize <= minBudgetPaletteSize {
+		return "", false
+	}
+	opts.PaletteSize = maxInt(opts.PaletteSize/2, minBudgetPaletteSize)
+	return fmt.Sprintf("cut the palette to %v colors", opts.PaletteSize), true
+}
+
+// shrinkFrames only goes as far as the length of animated input, since cutting frames any further
+// wouldn't change anything
+func (shrinker *budgetShrinker) shrinkFrames(opts *Options, _ image.Rectangle) (string, bool) {
+	minFrames := maxInt(minBudgetFrames, shrinker.numSourceFrames)
+	if opts.NumFrames <= minFrames {
+		return "", false
+	}
+	opts.NumFrames = maxInt(opts.NumFrames*3/4, minFrames)
+	return fmt.Sprintf("cut to %v frames per face", opts.NumFrames), true
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestBudgetShrinker(t *testing.T) {
+	t.Run("turns each knob in turn", func(t *testing.T) {
+		opts := DefaultOptions()
+		shrinker := budgetShrinker{}
+		var adjustments []string
+		for i := 0; i < 5; i++ {
+			adjustment, ok := shrinker.shrink(&opts, image.Rect(0, 0, 400, 300))
+			assert.True(t, ok)
+			adjustments = append(adjustments, adjustment)
+		}
+		assert.Equal(t, []string{
+			"turned off dithering",
+			"scaled down to 300x225",
+			"cut the palette to 128 colors",
+			"cut to 19 frames per face",
+			// dithering is already off, so it's on to the size again
+			"scaled down to 300x225",
+		}, adjustments)
+	})
+
+	t.Run("runs out of knobs", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Ditherer = DitherNone
+		opts.PaletteSize = minBudgetPaletteSize
+		opts.NumFrames = minBudgetFrames
+		shrinker := budgetShrinker{}
+		_, ok := shrinker.shrink(&opts, image.Rect(0, 0, 80, 60))
+		assert.False(t, ok)
+	})
+
+	t.Run("frames aren't cut below the length of an animation", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Ditherer = DitherNone
+		opts.PaletteSize = minBudgetPaletteSize
+		shrinker := budgetShrinker{numSourceFrames: 20}
+		adjustment, ok := shrinker.shrink(&opts, image.Rect(0, 0, 80, 60))
+		assert.True(t, ok)
+		assert.Equal(t, "cut to 20 frames per face", adjustment)
+
+		shrinker = budgetShrinker{numSourceFrames: 30}
+		_, ok = shrinker.shrink(&opts, image.Rect(0, 0, 80, 60))
+		assert.False(t, ok)
+	})
+}
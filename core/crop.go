@@ -1,18 +1,16 @@
 // handles the cropping and resizing of images
 
-package main
+package core
 
 import (
 	"fmt"
 	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
 	"image"
-	"image/color/palette"
 	"log"
+	"math"
 )
 
-//var blankImage = image.NewRGBA(image.Rectangle{})
-var blankPaletted = image.NewPaletted(image.Rectangle{}, palette.Plan9)
-
 func getBoundsWithAspectRatio(oldBounds, newBounds image.Rectangle) (image.Rectangle, error) {
 	// assert that newBounds is inside oldBounds
 	if !newBounds.In(oldBounds) {
@@ -48,49 +46,129 @@ func getBoundsWithAspectRatio(oldBounds, newBounds image.Rectangle) (image.Recta
 	}
 	log.Printf("scaledNewBounds before shift: %s", scaledNewBounds)
 	// now we shift the scaledNewBounds if they aren't fully enclosed in the original rect
-	if scaledNewBounds.In(oldBounds) {
-		return scaledNewBounds, nil
-	} else {
-		// the scaledNewBounds overlap with exactly one edge
-		// find that edge and shift by the difference, using Add
-		var shiftBy image.Point
-		if scaledNewBounds.Min.X < oldBounds.Min.X {
-			shiftBy = image.Point{X: oldBounds.Min.X - scaledNewBounds.Min.X, Y: 0}
-		} else if scaledNewBounds.Max.X > oldBounds.Max.X {
-			shiftBy = image.Point{X: oldBounds.Max.X - scaledNewBounds.Max.X, Y: 0}
-		} else if scaledNewBounds.Min.Y < oldBounds.Min.Y {
-			shiftBy = image.Point{X: 0, Y: oldBounds.Min.Y - scaledNewBounds.Min.Y}
-		} else if scaledNewBounds.Max.Y > oldBounds.Max.Y {
-			shiftBy = image.Point{X: 0, Y: oldBounds.Max.Y - scaledNewBounds.Max.Y}
-		}
-
-		return scaledNewBounds.Add(shiftBy), nil
+	return shiftInside(scaledNewBounds, oldBounds), nil
+}
+
+// centerBoundsOn moves bounds so they're centered on focus, as far as they can go without leaving oldBounds
+func centerBoundsOn(oldBounds, bounds image.Rectangle, focus image.Point) image.Rectangle {
+	center := image.Pt((bounds.Min.X+bounds.Max.X)/2, (bounds.Min.Y+bounds.Max.Y)/2)
+	return shiftInside(bounds.Add(focus.Sub(center)), oldBounds)
+}
+
+// shiftInside moves rect by the smallest amount that puts it inside within, clipping it if it's too big to fit
+func shiftInside(rect, within image.Rectangle) image.Rectangle {
+	var shiftBy image.Point
+	if rect.Min.X < within.Min.X {
+		shiftBy.X = within.Min.X - rect.Min.X
+	} else if rect.Max.X > within.Max.X {
+		shiftBy.X = within.Max.X - rect.Max.X
+	}
+	if rect.Min.Y < within.Min.Y {
+		shiftBy.Y = within.Min.Y - rect.Min.Y
+	} else if rect.Max.Y > within.Max.Y {
+		shiftBy.Y = within.Max.Y - rect.Max.Y
+	}
+	return rect.Add(shiftBy).Intersect(within)
+}
+
+// outputBounds scales bounds down to fit inside maxWidth x maxHeight, keeping the aspect ratio.
+// A max of 0 means there's no limit on that side.
+func outputBounds(bounds image.Rectangle, maxWidth, maxHeight int) image.Rectangle {
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	scale := 1.0
+	if maxWidth > 0 && width*scale > float64(maxWidth) {
+		scale = float64(maxWidth) / width
+	}
+	if maxHeight > 0 && height*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / height
+	}
+	if scale == 1.0 {
+		return bounds
 	}
+	return image.Rect(0, 0, int(math.Max(1, math.Round(width*scale))), int(math.Max(1, math.Round(height*scale))))
 }
 
-func Crop(img *image.Paletted, newBounds image.Rectangle) (*image.Paletted, error) {
-	bounds := img.Bounds()
+// PointF is an image.Point with float coordinates
+type PointF struct {
+	X, Y float64
+}
+
+// RectF is an image.Rectangle with float coordinates, for crop rects that fall between pixels
+type RectF struct {
+	Min, Max PointF
+}
 
-	if !newBounds.In(bounds) {
-		return blankPaletted, fmt.Errorf("newBounds not within bounds of original image, newBounds: " + newBounds.String())
+func toRectF(rect image.Rectangle) RectF {
+	return RectF{
+		Min: PointF{float64(rect.Min.X), float64(rect.Min.Y)},
+		Max: PointF{float64(rect.Max.X), float64(rect.Max.Y)},
 	}
+}
 
-	//newImage := image.NewRGBA(newBounds)
-	newImage := image.NewPaletted(newBounds, img.Palette)
-	//draw.Draw(newImage, newImage.Bounds(), img, newBounds.Min, draw.Src)
-	draw.Draw(newImage, newImage.Bounds(), img, newBounds.Min, draw.Src)
+func (rect RectF) Dx() float64 {
+	return rect.Max.X - rect.Min.X
+}
+
+func (rect RectF) Dy() float64 {
+	return rect.Max.Y - rect.Min.Y
+}
+
+// In reports whether rect is entirely inside bounds
+func (rect RectF) In(bounds image.Rectangle) bool {
+	return rect.Min.X >= float64(bounds.Min.X) && rect.Min.Y >= float64(bounds.Min.Y) &&
+		rect.Max.X <= float64(bounds.Max.X) && rect.Max.Y <= float64(bounds.Max.Y)
+}
 
-	return newImage, nil
+func (rect RectF) String() string {
+	return fmt.Sprintf("(%.2f,%.2f)-(%.2f,%.2f)", rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y)
 }
 
-func Resize(img *image.Paletted, bounds image.Rectangle) *image.Paletted {
-	dst := image.NewPaletted(bounds, img.Palette)
-	//draw.FloydSteinberg.Draw(dst, bounds, img, image.Point{0, 0})
-	//draw.ApproxBiLinear.Scale(dst, bounds, img, img.Bounds(), draw.Over, nil)
-	draw.NearestNeighbor.Scale(dst, bounds, img, img.Bounds(), draw.Over, nil)
-	return dst
-	//resized := resize.Resize(uint(bounds.Dx()), uint(bounds.Dy()), img, resize.Lanczos2)
-	//resizedPaletted, ok := resized.(image.Paletted)
+// shiftInsideF moves rect by the smallest amount that puts it inside within, clipping it if it's too big to fit
+func shiftInsideF(rect RectF, within image.Rectangle) RectF {
+	bounds := toRectF(within)
+	var shiftX, shiftY float64
+	if rect.Min.X < bounds.Min.X {
+		shiftX = bounds.Min.X - rect.Min.X
+	} else if rect.Max.X > bounds.Max.X {
+		shiftX = bounds.Max.X - rect.Max.X
+	}
+	if rect.Min.Y < bounds.Min.Y {
+		shiftY = bounds.Min.Y - rect.Min.Y
+	} else if rect.Max.Y > bounds.Max.Y {
+		shiftY = bounds.Max.Y - rect.Max.Y
+	}
+	return RectF{
+		Min: PointF{math.Max(rect.Min.X+shiftX, bounds.Min.X), math.Max(rect.Min.Y+shiftY, bounds.Min.Y)},
+		Max: PointF{math.Min(rect.Max.X+shiftX, bounds.Max.X), math.Min(rect.Max.Y+shiftY, bounds.Max.Y)},
+	}
+}
+
+// Crop samples the part of img inside cropTo, which can fall between pixels, scaled to fill outBounds.
+// Sampling through an affine transform rather than copying whole pixels keeps successive frames of a zoom
+// from wobbling as their rects round differently.
+func Crop(img image.Image, cropTo RectF, outBounds image.Rectangle, interp draw.Interpolator) (*image.RGBA, error) {
+	if !cropTo.In(img.Bounds()) || cropTo.Dx() <= 0 || cropTo.Dy() <= 0 {
+		return nil, fmt.Errorf("cropTo not within bounds of original image, cropTo: " + cropTo.String())
+	}
+
+	scaleX := float64(outBounds.Dx()) / cropTo.Dx()
+	scaleY := float64(outBounds.Dy()) / cropTo.Dy()
+	// maps source pixels to destination pixels
+	srcToDst := f64.Aff3{
+		scaleX, 0, float64(outBounds.Min.X) - cropTo.Min.X*scaleX,
+		0, scaleY, float64(outBounds.Min.Y) - cropTo.Min.Y*scaleY,
+	}
+	// the whole pixels the crop touches
+	srcRect := image.Rect(
+		int(math.Floor(cropTo.Min.X)),
+		int(math.Floor(cropTo.Min.Y)),
+		int(math.Ceil(cropTo.Max.X)),
+		int(math.Ceil(cropTo.Max.Y)),
+	).Intersect(img.Bounds())
+
+	dst := image.NewRGBA(outBounds)
+	interp.Transform(dst, srcToDst, img, srcRect, draw.Src, nil)
+	return dst, nil
 }
 
 //func main() {
@@ -106,14 +184,11 @@ func Resize(img *image.Paletted, bounds image.Rectangle) *image.Paletted {
 //	if err != nil {
 //		panic("had trouble getting scaled bounds")
 //	}
-//	croppedImg, err := Crop(img, cropBounds)
+//	resizedImg, err := Crop(img, toRectF(cropBounds), img.Bounds(), draw.CatmullRom)
 //	if err != nil {
 //		panic("had trouble cropping")
 //	}
 //
-//	//resizedImg := Resize(croppedImg, img.Bounds())
-//	resizedImg := croppedImg
-//
 //	outFile, err := os.Create("/tmp/cropped2.png")
 //	if err != nil {
 //		panic("had trouble opening outFile")
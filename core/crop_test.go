@@ -1,9 +1,11 @@
-package main
+package core
 
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
 	"image"
+	"image/color"
 	"testing"
 )
 
@@ -50,3 +52,52 @@ func TestGetBoundsWithAspectRatio(t *testing.T) {
 		assert.Equal(t, err, fmt.Errorf("newBounds not within bounds of original image"))
 	})
 }
+
+func TestCenterBoundsOn(t *testing.T) {
+	orig := image.Rect(0, 0, 200, 100)
+
+	t.Run("moves bounds onto the focus", func(t *testing.T) {
+		got := centerBoundsOn(orig, image.Rect(40, 40, 60, 50), image.Pt(100, 30))
+		assert.Equal(t, image.Rect(90, 25, 110, 35), got)
+	})
+
+	t.Run("stops at the edge of the original image", func(t *testing.T) {
+		got := centerBoundsOn(orig, image.Rect(40, 40, 60, 50), image.Pt(195, 2))
+		assert.Equal(t, image.Rect(180, 0, 200, 10), got)
+	})
+}
+
+func TestOutputBounds(t *testing.T) {
+	orig := image.Rect(0, 0, 400, 300)
+	assert.Equal(t, orig, outputBounds(orig, 0, 0))
+	assert.Equal(t, orig, outputBounds(orig, 800, 600))
+	assert.Equal(t, image.Rect(0, 0, 200, 150), outputBounds(orig, 200, 0))
+	assert.Equal(t, image.Rect(0, 0, 200, 150), outputBounds(orig, 0, 150))
+	assert.Equal(t, image.Rect(0, 0, 100, 75), outputBounds(orig, 200, 75))
+}
+
+func TestCrop(t *testing.T) {
+	// a horizontal gradient, so sub-pixel shifts show up as changes in gray level
+	img := image.NewGray(image.Rect(0, 0, 100, 10))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 10; y++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(2 * x)})
+		}
+	}
+	outBounds := image.Rect(0, 0, 20, 2)
+
+	t.Run("fractional offsets shift the sampled pixels", func(t *testing.T) {
+		whole, err := Crop(img, RectF{PointF{40, 4}, PointF{60, 6}}, outBounds, draw.CatmullRom)
+		assert.Nil(t, err)
+		shifted, err := Crop(img, RectF{PointF{40.5, 4}, PointF{60.5, 6}}, outBounds, draw.CatmullRom)
+		assert.Nil(t, err)
+		assert.Equal(t, outBounds, shifted.Bounds())
+		assert.Equal(t, uint8(80), whole.RGBAAt(0, 0).R)
+		assert.Equal(t, uint8(81), shifted.RGBAAt(0, 0).R)
+	})
+
+	t.Run("not within bounds", func(t *testing.T) {
+		_, err := Crop(img, RectF{PointF{90.5, 0}, PointF{100.5, 10}}, outBounds, draw.CatmullRom)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,116 @@
+// draws what the detector found, for figuring out why the wrong face got zoomed
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+var (
+	debugDetectionColor = color.RGBA{R: 255, G: 220, A: 255}   // every face found
+	debugChosenColor    = color.RGBA{G: 255, A: 255}           // the faces zoomed into
+	debugCropColor      = color.RGBA{R: 255, B: 255, A: 255}   // where each zoom ends up
+	debugLabelBackdrop  = image.NewUniform(color.RGBA{A: 180}) // behind labels, so they show up on anything
+)
+
+// DebugOverlay draws plan and detections over a copy of img. Every face found is outlined and labelled with
+// its rank, pigo's Q and the score it was ranked by. The faces the plan zooms into are highlighted, and the
+// aspect-corrected crop box each zoom ends up on is outlined too.
+func DebugOverlay(img image.Image, detections []FaceDetection, plan *Plan) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+	// lines thick enough to see on a phone photo without drowning a thumbnail
+	lineWidth := maxInt(1, minInt(bounds.Dx(), bounds.Dy())/250)
+
+	chosen := make(map[image.Rectangle]bool)
+	for _, face := range plan.Faces {
+		chosen[face.Rect] = true
+	}
+	for i, face := range detections {
+		faceColor := debugDetectionColor
+		if chosen[face.Rect] {
+			faceColor = debugChosenColor
+			delete(chosen, face.Rect)
+		}
+		strokeRect(dst, face.Rect, lineWidth, faceColor)
+		drawLabel(dst, face.Rect.Min, fmt.Sprintf("#%v q=%.1f score=%.2f", i+1, face.Q, face.Score), faceColor)
+	}
+	// zoomed into, but not found by the detector
+	for _, face := range plan.Faces {
+		if chosen[face.Rect] {
+			strokeRect(dst, face.Rect, lineWidth, debugChosenColor)
+			drawLabel(dst, face.Rect.Min, "target", debugChosenColor)
+		}
+	}
+	for _, target := range plan.Targets {
+		strokeRect(dst, target, lineWidth, debugCropColor)
+		drawLabel(dst, image.Pt(target.Min.X, target.Max.Y+basicfont.Face7x13.Height), "crop", debugCropColor)
+	}
+	return dst
+}
+
+// strokeRect outlines rect in c, drawing the lines inside it
+func strokeRect(dst draw.Image, rect image.Rectangle, width int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, edge := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+width),
+		image.Rect(rect.Min.X, rect.Max.Y-width, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+width, rect.Max.Y),
+		image.Rect(rect.Max.X-width, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	} {
+		draw.Draw(dst, edge.Intersect(rect), src, image.Point{}, draw.Src)
+	}
+}
+
+// drawLabel writes text just above at, shifted inside dst if it would run off the edge
+func drawLabel(dst draw.Image, at image.Point, text string, c color.Color) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	box := image.Rect(at.X, at.Y-face.Height, at.X+width, at.Y)
+	box = shiftInside(box, dst.Bounds())
+	draw.Draw(dst, box, debugLabelBackdrop, image.Point{}, draw.Over)
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(box.Min.X, box.Min.Y+face.Ascent),
+	}
+	drawer.DrawString(text)
+}
+
+// CreateDebugPNG plans a gif like CreateGif would, then writes the first frame of the image to w as a PNG
+// with DebugOverlay drawn over it instead of rendering the gif. For animated input, each face found is drawn
+// where it was best detected, which might not be in the first frame.
+func CreateDebugPNG(ctx context.Context, r io.Reader, w io.Writer, opts Options) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panicked while drawing the debug overlay: %v", p)
+		}
+	}()
+	if err = opts.Validate(); err != nil {
+		return err
+	}
+	planned, err := planGif(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	plan, err := planned.plan(opts)
+	if err != nil {
+		return err
+	}
+	overlay := DebugOverlay(planned.sources[0], planned.detected, plan)
+	if err = png.Encode(w, overlay); err != nil {
+		return fmt.Errorf("had trouble encoding the debug overlay: %s", err.Error())
+	}
+	return nil
+}
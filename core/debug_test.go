@@ -0,0 +1,41 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestDebugOverlay(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	chosen := FaceDetection{Rect: image.Rect(20, 30, 60, 70), Q: 9.5, Score: 2}
+	other := FaceDetection{Rect: image.Rect(120, 30, 150, 60), Q: 4.2, Score: 1}
+	plan, err := PlanZoom(img.Bounds(), []FaceDetection{chosen}, DefaultOptions())
+	assert.Nil(t, err)
+
+	got := DebugOverlay(img, []FaceDetection{chosen, other}, plan)
+	assert.Equal(t, img.Bounds(), got.Bounds())
+	// the chosen face is highlighted, the other one isn't
+	assert.Equal(t, debugChosenColor, got.RGBAAt(20, 50))
+	assert.Equal(t, debugDetectionColor, got.RGBAAt(135, 59))
+	// the crop box is wider than the face, to match the image
+	assert.Equal(t, debugCropColor, got.RGBAAt(plan.Targets[0].Min.X+5, plan.Targets[0].Min.Y))
+	// the labels are in there somewhere
+	assert.NotEqual(t, color.RGBA{}, got.RGBAAt(22, 25))
+	// and img is left alone
+	assert.Equal(t, color.RGBA{}, img.RGBAAt(20, 50))
+}
+
+func TestCreateDebugPNG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Fallback = FallbackCenter
+	var out bytes.Buffer
+	assert.Nil(t, CreateDebugPNG(context.Background(), bytes.NewReader(blankPNG(t, 120, 80)), &out, opts))
+	img, err := png.Decode(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 120, 80), img.Bounds())
+}
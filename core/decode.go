@@ -0,0 +1,263 @@
+// decodes input images
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// UnsupportedFormatError is returned for inputs that aren't in a format we can decode
+type UnsupportedFormatError struct {
+	Format string // what the input looks like it is, e.g. "heic" or "text/plain; charset=utf-8"
+}
+
+func (err *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("can't make a gif out of a %s file", err.Format)
+}
+
+// ErrTooManyPixels is returned for images too big to decode, see maxDecodedPixels
+var ErrTooManyPixels = errors.New("image has too many pixels")
+
+// the most pixels we'll decode, a little over a 48 megapixel photo. Decoding allocates the whole image up front
+// from a size in the header, so a tiny file can claim to be 50000x50000 and run us out of memory, which there's
+// no recovering from.
+const maxDecodedPixels = 50000000
+
+type imageFormat struct {
+	name         string
+	magic        string // what files of this format start with, ? matches any byte
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+}
+
+// formats are matched by their magic bytes rather than file extensions or content types, which lie.
+// Formats with a nil decode are ones we recognize but can't decode, so we can say what they are.
+var formats = []imageFormat{
+	{"jpeg", "\xff\xd8\xff", jpeg.Decode, jpeg.DecodeConfig},
+	{"png", "\x89PNG\r\n\x1a\n", png.Decode, png.DecodeConfig},
+	{"gif", "GIF87a", gif.Decode, gif.DecodeConfig},
+	{"gif", "GIF89a", gif.Decode, gif.DecodeConfig},
+	{"webp", "RIFF????WEBPVP8", webp.Decode, webp.DecodeConfig},
+	{"bmp", "BM", bmp.Decode, bmp.DecodeConfig},
+	{"tiff", "II*\x00", tiff.Decode, tiff.DecodeConfig},
+	{"tiff", "MM\x00*", tiff.Decode, tiff.DecodeConfig},
+	{"heic", "????ftypheic", nil, nil},
+	{"heic", "????ftypheix", nil, nil},
+	{"heic", "????ftypmif1", nil, nil},
+	{"avif", "????ftypavif", nil, nil},
+	{"pdf", "%PDF", nil, nil},
+}
+
+func matchesMagic(data []byte, magic string) bool {
+	if len(data) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sniffFormat(data []byte) (imageFormat, error) {
+	for _, format := range formats {
+		if !matchesMagic(data, format.magic) {
+			continue
+		}
+		if format.decode == nil {
+			return format, &UnsupportedFormatError{Format: format.name}
+		}
+		return format, nil
+	}
+	return imageFormat{}, &UnsupportedFormatError{Format: http.DetectContentType(data)}
+}
+
+// SniffFormat names the format of an image from its first few bytes, e.g. "jpeg" or "webp", which also works as
+// a file extension. Formats Decode can't handle return an *UnsupportedFormatError.
+func SniffFormat(data []byte) (string, error) {
+	format, err := sniffFormat(data)
+	return format.name, err
+}
+
+// Decode reads a JPEG, PNG, GIF, WebP, BMP or TIFF image from r, returning an *UnsupportedFormatError for anything
+// else. JPEGs are turned the right way up according to their EXIF orientation, since phones store photos sideways
+// and let the viewer rotate them. Only the first frame of an animated GIF is read, see DecodeFrames.
+// Images over maxDecodedPixels return ErrTooManyPixels without being decoded.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("had trouble reading the image: %s", err.Error())
+	}
+	return decodeImage(data)
+}
+
+// DecodeFrames is Decode for animated GIFs. It returns every frame, each drawn over the frames before it the way
+// a viewer would show it, along with how long each is shown for. Animations longer than maxFramesPerFace frames
+// have frames dropped evenly to fit, keeping their timing, though every frame is still decoded on the way, so
+// it's the whole animation that has to fit in maxDecodedPixels. Anything else comes back as a single frame with
+// a delay of 0.
+func DecodeFrames(r io.Reader) ([]image.Image, []int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("had trouble reading the image: %s", err.Error())
+	}
+	if format, _ := sniffFormat(data); format.name == "gif" {
+		return decodeGifFrames(data)
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []image.Image{img}, []int{0}, nil
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	format, err := sniffFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPixels(format, data, 1); err != nil {
+		return nil, err
+	}
+	img, err := format.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("had trouble decoding the %s image: %s", format.name, err.Error())
+	}
+	// broken metadata shouldn't stop us from using a perfectly good image
+	orientation := orientationNormal
+	exif, err := findExif(data)
+	if err == nil && exif != nil {
+		orientation, err = exif.orientation()
+	}
+	if err != nil {
+		log.Printf("had trouble reading the EXIF orientation, leaving the image as is: %s", err.Error())
+	}
+	return applyOrientation(img, orientation), nil
+}
+
+// checkPixels returns ErrTooManyPixels if decoding frames frames of data, going by the size in its header,
+// would take more than maxDecodedPixels
+func checkPixels(format imageFormat, data []byte, frames int) error {
+	config, err := format.decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("had trouble decoding the %s image: %s", format.name, err.Error())
+	}
+	if pixels := float64(config.Width) * float64(config.Height) * float64(frames); pixels > maxDecodedPixels {
+		return fmt.Errorf("%w: decoding it would take %.0f pixels, over the limit of %v",
+			ErrTooManyPixels, pixels, maxDecodedPixels)
+	}
+	return nil
+}
+
+// countGifFrames counts the frames in a gif by skipping over its blocks, without decoding any of them
+func countGifFrames(data []byte) (int, error) {
+	// the header, then the logical screen descriptor, maybe followed by a global color table
+	if len(data) < 13 {
+		return 0, fmt.Errorf("gif is too short")
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	// skipSubBlocks skips the length-prefixed blocks of data that end every extension and image
+	skipSubBlocks := func() {
+		for i < len(data) && data[i] != 0 {
+			i += 1 + int(data[i])
+		}
+		i++
+	}
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // an extension, a label then sub-blocks
+			i += 2
+			skipSubBlocks()
+		case 0x2C: // an image descriptor, maybe a local color table, the LZW code size then sub-blocks
+			if i+10 > len(data) {
+				return frames, fmt.Errorf("gif image descriptor at byte %v runs past the end of the file", i)
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i++
+			skipSubBlocks()
+			frames++
+		case 0x3B: // the trailer
+			return frames, nil
+		default:
+			return frames, fmt.Errorf("unexpected gif block %#x at byte %v", data[i], i)
+		}
+	}
+	return frames, nil
+}
+
+func decodeGifFrames(data []byte) ([]image.Image, []int, error) {
+	// any frame can be as big as the canvas, and DecodeAll holds every one of them before we get to drop any,
+	// so the whole animation has to fit within maxDecodedPixels, not just the frames we'd keep
+	numFrames, err := countGifFrames(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("had trouble decoding the gif image: %s", err.Error())
+	}
+	format, _ := sniffFormat(data)
+	if err = checkPixels(format, data, numFrames); err != nil {
+		return nil, nil, err
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("had trouble decoding the gif image: %s", err.Error())
+	}
+	// every frame we keep is a full size copy that faces get looked for in, so long animations only keep every
+	// step-th frame, shown for as long as the frames it stands in for, to stay within maxFramesPerFace
+	step := (len(anim.Image) + maxFramesPerFace - 1) / maxFramesPerFace
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	var frames []image.Image
+	var delays []int
+	for i, frame := range anim.Image {
+		var disposal byte
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i%step == 0 {
+			frames = append(frames, cloneRGBA(canvas))
+			delays = append(delays, 0)
+		}
+		if i < len(anim.Delay) {
+			delays[len(delays)-1] += anim.Delay[i]
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, delays, nil
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := *img
+	clone.Pix = append([]uint8(nil), img.Pix...)
+	return &clone
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestDecodeFormats(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"png":  png.Encode,
+		"bmp":  bmp.Encode,
+		"gif":  func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) },
+		"tiff": func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) },
+	}
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.Nil(t, encode(&buf, img))
+			format, err := SniffFormat(buf.Bytes())
+			assert.Nil(t, err)
+			assert.Equal(t, name, format)
+			decoded, err := Decode(&buf)
+			assert.Nil(t, err)
+			assert.Equal(t, img.Bounds(), decoded.Bounds())
+		})
+	}
+
+	t.Run("webp", func(t *testing.T) {
+		// a 1x1 lossless webp
+		data, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+		format, err := SniffFormat(data)
+		assert.Nil(t, err)
+		assert.Equal(t, "webp", format)
+		decoded, err := Decode(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 1, 1), decoded.Bounds())
+	})
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	var unsupported *UnsupportedFormatError
+
+	t.Run("recognized", func(t *testing.T) {
+		_, err := Decode(bytes.NewReader([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")))
+		assert.True(t, errors.As(err, &unsupported))
+		assert.Equal(t, "heic", unsupported.Format)
+		assert.Equal(t, "can't make a gif out of a heic file", err.Error())
+	})
+
+	t.Run("unrecognized", func(t *testing.T) {
+		_, err := Decode(bytes.NewReader([]byte("just some text")))
+		assert.True(t, errors.As(err, &unsupported))
+		assert.Equal(t, "text/plain; charset=utf-8", unsupported.Format)
+	})
+}
+
+func TestDecodeTooManyPixels(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))))
+	bomb := buf.Bytes()
+	// claim to be 50000x50000 in the IHDR chunk, which starts after the 8 byte signature
+	binary.BigEndian.PutUint32(bomb[16:], 50000)
+	binary.BigEndian.PutUint32(bomb[20:], 50000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+
+	_, err := Decode(bytes.NewReader(bomb))
+	assert.True(t, errors.Is(err, ErrTooManyPixels))
+	_, _, err = DecodeFrames(bytes.NewReader(bomb))
+	assert.True(t, errors.Is(err, ErrTooManyPixels))
+}
+
+// animatedGif is a red 40x20 background with a 4x4 blue square moving right by 4 pixels every frame.
+// Every frame after the first only covers the square, so it needs drawing over the frames before it.
+func animatedGif(t *testing.T, delays []int) []byte {
+	palette := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	anim := &gif.GIF{Delay: delays}
+	for i := range delays {
+		bounds := image.Rect(0, 0, 40, 20)
+		if i > 0 {
+			bounds = image.Rect(i*4-4, 8, i*4+4, 12)
+		}
+		frame := image.NewPaletted(bounds, palette)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if x >= i*4 && x < i*4+4 && y >= 8 && y < 12 {
+					frame.SetColorIndex(x, y, 1)
+				}
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, gif.EncodeAll(&buf, anim))
+	return buf.Bytes()
+}
+
+func TestDecodeFrames(t *testing.T) {
+	t.Run("animated gifs", func(t *testing.T) {
+		frames, delays, err := DecodeFrames(bytes.NewReader(animatedGif(t, []int{3, 7, 0})))
+		assert.Nil(t, err)
+		assert.Equal(t, []int{3, 7, 0}, delays)
+		assert.Len(t, frames, 3)
+		red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+		for i, frame := range frames {
+			assert.Equal(t, image.Rect(0, 0, 40, 20), frame.Bounds())
+			assert.Equal(t, blue, color.RGBAModel.Convert(frame.At(i*4, 8)), "frame %v", i)
+			// the background from the first frame shows through the later ones
+			assert.Equal(t, red, color.RGBAModel.Convert(frame.At(30, 2)), "frame %v", i)
+		}
+		assert.Equal(t, red, color.RGBAModel.Convert(frames[2].At(0, 8)))
+	})
+
+	t.Run("long animations are cut down to maxFramesPerFace frames", func(t *testing.T) {
+		palette := color.Palette{color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 2*maxFramesPerFace+50; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+			anim.Delay = append(anim.Delay, 1)
+		}
+		var buf bytes.Buffer
+		assert.Nil(t, gif.EncodeAll(&buf, anim))
+		frames, delays, err := DecodeFrames(&buf)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(frames), maxFramesPerFace)
+		assert.Len(t, delays, len(frames))
+		// every third frame is kept, standing in for the two after it
+		assert.Equal(t, 3, delays[0])
+		total := 0
+		for _, delay := range delays {
+			total += delay
+		}
+		assert.Equal(t, len(anim.Image), total)
+	})
+
+	t.Run("animations too long to decode are refused before decoding them", func(t *testing.T) {
+		// 51 frames that could each be as big as the megapixel canvas is too many, however small they really are
+		anim := &gif.GIF{Config: image.Config{Width: 1000, Height: 1000}}
+		for i := 0; i < 51; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}))
+			anim.Delay = append(anim.Delay, 1)
+		}
+		var buf bytes.Buffer
+		assert.Nil(t, gif.EncodeAll(&buf, anim))
+		frames, err := countGifFrames(buf.Bytes())
+		assert.Nil(t, err)
+		assert.Equal(t, 51, frames)
+		_, _, err = DecodeFrames(bytes.NewReader(buf.Bytes()))
+		assert.True(t, errors.Is(err, ErrTooManyPixels))
+	})
+
+	t.Run("anything else is a single frame", func(t *testing.T) {
+		frames, delays, err := DecodeFrames(bytes.NewReader(blankPNG(t, 6, 4)))
+		assert.Nil(t, err)
+		assert.Len(t, frames, 1)
+		assert.Equal(t, []int{0}, delays)
+	})
+}
@@ -0,0 +1,147 @@
+// dithering algorithms for mapping frames onto their palettes
+
+package core
+
+import (
+	"fmt"
+	"github.com/esimov/colorquant"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Ditherer draws img onto dst, using only the colors in dst's palette
+type Ditherer interface {
+	Dither(img image.Image, dst *image.Paletted)
+}
+
+// DitherFunc lets an ordinary function be a Ditherer
+type DitherFunc func(img image.Image, dst *image.Paletted)
+
+func (f DitherFunc) Dither(img image.Image, dst *image.Paletted) {
+	f(img, dst)
+}
+
+// errorDiffusion spreads each pixel's error onto its unvisited neighbors with filter. colorquant reads
+// filter[i][j] as the pixel i columns right and j-len(filter)+1 rows down, working down each column in turn,
+// so filters need 3 rows to put the current pixel at [0][2]. That transposes the usual layout, which is fine
+// since the scan is transposed too, but the fifth column is never read.
+func errorDiffusion(filter [][]float32) Ditherer {
+	ditherer := colorquant.Dither{Filter: filter}
+	return DitherFunc(func(img image.Image, dst *image.Paletted) {
+		ditherer.Quantize(img, dst, len(dst.Palette), true, false)
+	})
+}
+
+var (
+	// DitherFloydSteinberg is the classic, spreading the error over the four nearest pixels
+	DitherFloydSteinberg = errorDiffusion([][]float32{
+		{0.0, 0.0, 0.0, 7.0 / 16.0, 0.0},
+		{0.0, 3.0 / 16.0, 5.0 / 16.0, 1.0 / 16.0, 0.0},
+		{0.0, 0.0, 0.0, 0.0, 0.0},
+	})
+	// DitherAtkinson only spreads 3/4 of the error, giving crisper, higher contrast results like an old Mac
+	DitherAtkinson = errorDiffusion([][]float32{
+		{0.0, 0.0, 0.0, 1.0 / 8.0, 1.0 / 8.0},
+		{0.0, 1.0 / 8.0, 1.0 / 8.0, 1.0 / 8.0, 0.0},
+		{0.0, 0.0, 1.0 / 8.0, 0.0, 0.0},
+	})
+	// DitherJarvisJudiceNinke spreads the error the furthest, for the smoothest gradients
+	DitherJarvisJudiceNinke = errorDiffusion([][]float32{
+		{0.0, 0.0, 0.0, 7.0 / 48.0, 5.0 / 48.0},
+		{3.0 / 48.0, 5.0 / 48.0, 7.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0},
+		{1.0 / 48.0, 3.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0, 1.0 / 48.0},
+	})
+	// DitherSierra is nearly as smooth as Jarvis-Judice-Ninke
+	DitherSierra = errorDiffusion([][]float32{
+		{0.0, 0.0, 0.0, 5.0 / 32.0, 3.0 / 32.0},
+		{2.0 / 32.0, 4.0 / 32.0, 5.0 / 32.0, 4.0 / 32.0, 2.0 / 32.0},
+		{0.0, 2.0 / 32.0, 3.0 / 32.0, 2.0 / 32.0, 0.0},
+	})
+	// DitherBayer4 and DitherBayer8 are ordered dithers. Every pixel is nudged by a fixed threshold for its position,
+	// so the pattern stays put from one frame to the next instead of shimmering like error diffusion does.
+	DitherBayer4 = orderedDither(bayerMatrix(4))
+	DitherBayer8 = orderedDither(bayerMatrix(8))
+)
+
+// DitherNone maps every pixel to its closest palette color, which bands but makes the smallest gifs.
+// Unlike the other ditherers it can be compared with ==, to tell whether dithering is off.
+var DitherNone Ditherer = noDither{}
+
+type noDither struct{}
+
+func (noDither) Dither(img image.Image, dst *image.Paletted) {
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+}
+
+// bayerMatrix builds a size x size threshold map, size being a power of 2, with thresholds spread evenly
+// between -0.5 and 0.5
+func bayerMatrix(size int) [][]float64 {
+	indices := [][]int{{0}}
+	for n := 1; n < size; n *= 2 {
+		// each step tiles 4 copies of the previous matrix, offset so they interleave
+		next := make([][]int, 2*n)
+		for y := range next {
+			next[y] = make([]int, 2*n)
+			for x := range next[y] {
+				offset := [2][2]int{{0, 2}, {3, 1}}[y/n][x/n]
+				next[y][x] = 4*indices[y%n][x%n] + offset
+			}
+		}
+		indices = next
+	}
+	matrix := make([][]float64, size)
+	for y := range matrix {
+		matrix[y] = make([]float64, size)
+		for x := range matrix[y] {
+			matrix[y][x] = (float64(indices[y][x])+0.5)/float64(size*size) - 0.5
+		}
+	}
+	return matrix
+}
+
+func orderedDither(matrix [][]float64) Ditherer {
+	size := len(matrix)
+	return DitherFunc(func(img image.Image, dst *image.Paletted) {
+		// roughly the distance between neighboring palette colors, if they were spread evenly over the color cube
+		spread := 255 / math.Cbrt(float64(len(dst.Palette)))
+		bounds := dst.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				nudge := spread * matrix[y%size][x%size]
+				nudged := color.RGBA{
+					R: clampUint8(float64(c.R) + nudge),
+					G: clampUint8(float64(c.G) + nudge),
+					B: clampUint8(float64(c.B) + nudge),
+					A: c.A,
+				}
+				dst.SetColorIndex(x, y, uint8(dst.Palette.Index(nudged)))
+			}
+		}
+	})
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+var ditherers = map[string]Ditherer{
+	"floyd-steinberg": DitherFloydSteinberg,
+	"atkinson":        DitherAtkinson,
+	"jjn":             DitherJarvisJudiceNinke,
+	"sierra":          DitherSierra,
+	"bayer4":          DitherBayer4,
+	"bayer8":          DitherBayer8,
+	"none":            DitherNone,
+}
+
+// DitherByName looks up a ditherer by the name users pick it with, e.g. "bayer8"
+func DitherByName(name string) (Ditherer, error) {
+	ditherer, ok := ditherers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ditherer %q", name)
+	}
+	return ditherer, nil
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestBayerMatrix(t *testing.T) {
+	t.Run("2x2", func(t *testing.T) {
+		assert.Equal(t, [][]float64{{-0.375, 0.125}, {0.375, -0.125}}, bayerMatrix(2))
+	})
+
+	t.Run("8x8 uses every threshold once", func(t *testing.T) {
+		seen := make(map[float64]bool)
+		for _, row := range bayerMatrix(8) {
+			for _, threshold := range row {
+				assert.Greater(t, threshold, -0.5)
+				assert.Less(t, threshold, 0.5)
+				seen[threshold] = true
+			}
+		}
+		assert.Len(t, seen, 64)
+	})
+}
+
+func TestOrderedDither(t *testing.T) {
+	gray := image.NewUniform(color.RGBA{R: 128, G: 128, B: 128, A: 255})
+	dst := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	DitherBayer4.Dither(gray, dst)
+	// half gray comes out as an even checker of black and white
+	whites := 0
+	for _, index := range dst.Pix {
+		whites += int(index)
+	}
+	assert.Equal(t, 32, whites)
+	// and the pattern repeats every 4 pixels, so it holds still between frames
+	assert.Equal(t, dst.ColorIndexAt(1, 2), dst.ColorIndexAt(5, 6))
+}
+
+func TestDitherNone(t *testing.T) {
+	img := image.NewUniform(color.RGBA{R: 200, G: 200, B: 200, A: 255})
+	dst := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	DitherNone.Dither(img, dst)
+	for _, index := range dst.Pix {
+		assert.Equal(t, uint8(1), index)
+	}
+}
+
+func TestDitherByName(t *testing.T) {
+	_, err := DitherByName("atkinson")
+	assert.Nil(t, err)
+	_, err = DitherByName("sparkly")
+	assert.NotNil(t, err)
+}
+
+func TestDitherGradient(t *testing.T) {
+	// black to white from left to right, onto just 4 grays
+	gradient := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(x * 4)
+			gradient.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	palette := color.Palette{
+		color.RGBA{A: 255},
+		color.RGBA{R: 85, G: 85, B: 85, A: 255},
+		color.RGBA{R: 170, G: 170, B: 170, A: 255},
+		color.RGBA{R: 255, G: 255, B: 255, A: 255},
+	}
+	// how far each 8x8 block is on average from the gradient, since dithering trades error in each pixel for
+	// getting the average right
+	blockError := func(dst *image.Paletted) float64 {
+		var total float64
+		for by := 0; by < 64; by += 8 {
+			for bx := 0; bx < 64; bx += 8 {
+				var diff float64
+				for y := by; y < by+8; y++ {
+					for x := bx; x < bx+8; x++ {
+						diff += float64(palette[dst.ColorIndexAt(x, y)].(color.RGBA).R) - float64(gradient.RGBAAt(x, y).R)
+					}
+				}
+				total += math.Abs(diff / 64)
+			}
+		}
+		return total / 64
+	}
+	undithered := image.NewPaletted(gradient.Bounds(), palette)
+	DitherNone.Dither(gradient, undithered)
+
+	for name, ditherer := range ditherers {
+		if ditherer == DitherNone {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			dst := image.NewPaletted(gradient.Bounds(), palette)
+			assert.NotPanics(t, func() { ditherer.Dither(gradient, dst) })
+			for _, index := range dst.Pix {
+				assert.Less(t, int(index), len(palette))
+			}
+			assert.Less(t, blockError(dst), blockError(undithered))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// easing curves for the zoom
+
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// Easing maps how far through the zoom we are in time, from 0 to 1, onto how far the zoom has gotten.
+// It should map 0 to 0 and 1 to 1, but can overshoot past 1 in between.
+type Easing func(t float64) float64
+
+// EaseLinear zooms at one flat speed
+func EaseLinear(t float64) float64 {
+	return t
+}
+
+// EaseInCubic starts slow and speeds up, slamming into the face like a dramatic zoom
+func EaseInCubic(t float64) float64 {
+	return t * t * t
+}
+
+// EaseOutCubic starts fast and settles gently onto the face
+func EaseOutCubic(t float64) float64 {
+	return 1 - math.Pow(1-t, 3)
+}
+
+// EaseInOutCubic is slow at both ends and fast in the middle
+func EaseInOutCubic(t float64) float64 {
+	if t < 0.5 {
+		return 4 * t * t * t
+	}
+	return 1 - math.Pow(-2*t+2, 3)/2
+}
+
+// EaseInExpo barely moves at first, then rushes in at the end
+func EaseInExpo(t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return math.Pow(2, 10*t-10)
+}
+
+// EaseOutExpo rushes in, then creeps up on the face
+func EaseOutExpo(t float64) float64 {
+	if t >= 1 {
+		return 1
+	}
+	return 1 - math.Pow(2, -10*t)
+}
+
+// EaseOutElastic overshoots the face and springs back a few times before settling
+func EaseOutElastic(t float64) float64 {
+	if t <= 0 || t >= 1 {
+		return t
+	}
+	return math.Pow(2, -10*t)*math.Sin((t*10-0.75)*(2*math.Pi/3)) + 1
+}
+
+// EaseOutBounce hits the face and bounces back off it a few times
+func EaseOutBounce(t float64) float64 {
+	const n, d = 7.5625, 2.75
+	switch {
+	case t < 1/d:
+		return n * t * t
+	case t < 2/d:
+		t -= 1.5 / d
+		return n*t*t + 0.75
+	case t < 2.5/d:
+		t -= 2.25 / d
+		return n*t*t + 0.9375
+	default:
+		t -= 2.625 / d
+		return n*t*t + 0.984375
+	}
+}
+
+var easings = map[string]Easing{
+	"linear":      EaseLinear,
+	"ease-in":     EaseInCubic,
+	"ease-out":    EaseOutCubic,
+	"ease-in-out": EaseInOutCubic,
+	"expo-in":     EaseInExpo,
+	"expo-out":    EaseOutExpo,
+	"elastic":     EaseOutElastic,
+	"bounce":      EaseOutBounce,
+}
+
+// EasingByName looks up an easing by the name users pick it with: linear, ease-in, ease-out, ease-in-out,
+// expo-in, expo-out, elastic or bounce
+func EasingByName(name string) (Easing, error) {
+	easing, ok := easings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown easing %q", name)
+	}
+	return easing, nil
+}
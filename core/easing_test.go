@@ -0,0 +1,26 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEasings(t *testing.T) {
+	for name, easing := range easings {
+		t.Run(name, func(t *testing.T) {
+			assert.InDelta(t, 0, easing(0), 1e-3)
+			assert.InDelta(t, 1, easing(1), 1e-3)
+		})
+	}
+
+	t.Run("elastic overshoots", func(t *testing.T) {
+		assert.Greater(t, EaseOutElastic(0.2), 1.0)
+	})
+
+	t.Run("by name", func(t *testing.T) {
+		_, err := EasingByName("ease-in-out")
+		assert.Nil(t, err)
+		_, err = EasingByName("wobbly")
+		assert.NotNil(t, err)
+	})
+}
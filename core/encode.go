@@ -0,0 +1,145 @@
+// encodes rendered frames as a gif
+
+package core
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// a gif palette holds 256 colors, and Encode needs one of them free to make unchanged pixels transparent
+const maxOpaqueColors = 255
+
+// Encode writes anim to w. Every frame after the first is cut down to the box of pixels that changed since the
+// frame before, with the pixels inside it that didn't change left transparent, so the gif looks the same but only
+// stores what moves.
+func Encode(w io.Writer, anim *gif.GIF) error {
+	if len(anim.Image) == 0 {
+		return fmt.Errorf("can't encode a gif without any frames")
+	}
+	if err := gif.EncodeAll(w, deltaEncode(anim)); err != nil {
+		return fmt.Errorf("had trouble encoding the gif: %s", err.Error())
+	}
+	return nil
+}
+
+// deltaEncode returns a copy of anim whose frames only hold the pixels that changed from the frame before.
+// Every frame is left in place for the next to draw over, which is what makes this look the same as anim.
+func deltaEncode(anim *gif.GIF) *gif.GIF {
+	palettes := transparentPalettes{}
+	delta := *anim
+	delta.Image = make([]*image.Paletted, len(anim.Image))
+	delta.Disposal = make([]byte, len(anim.Image))
+	if globalPalette, ok := anim.Config.ColorModel.(color.Palette); ok {
+		delta.Config.ColorModel, _ = palettes.get(globalPalette)
+	}
+
+	// the first frame is drawn in full, it just needs the same palette as the global one to match it
+	first := *anim.Image[0]
+	first.Palette, _ = palettes.get(first.Palette)
+	delta.Image[0] = &first
+	delta.Disposal[0] = gif.DisposalNone
+	for i := 1; i < len(anim.Image); i++ {
+		delta.Image[i] = frameDelta(anim.Image[i-1], anim.Image[i], palettes)
+		delta.Disposal[i] = gif.DisposalNone
+	}
+	return &delta
+}
+
+// frameDelta crops frame down to the pixels that differ from prev, making the pixels in that box that don't
+// differ transparent if the palette has room for a transparent color
+func frameDelta(prev, frame *image.Paletted, palettes transparentPalettes) *image.Paletted {
+	bounds := frame.Bounds()
+	changed := image.Rectangle{Min: bounds.Max, Max: bounds.Min}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if pixelChanged(prev, frame, x, y) {
+				changed.Min.X, changed.Max.X = minInt(changed.Min.X, x), maxInt(changed.Max.X, x+1)
+				changed.Min.Y, changed.Max.Y = minInt(changed.Min.Y, y), maxInt(changed.Max.Y, y+1)
+			}
+		}
+	}
+	if changed.Empty() {
+		// gifs can't have empty frames, so a frame that changes nothing is a single pixel
+		changed = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1)
+	}
+
+	palette, transparentIndex := palettes.get(frame.Palette)
+	delta := image.NewPaletted(changed, palette)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			index := frame.ColorIndexAt(x, y)
+			if transparentIndex >= 0 && !pixelChanged(prev, frame, x, y) {
+				index = uint8(transparentIndex)
+			}
+			delta.SetColorIndex(x, y, index)
+		}
+	}
+	return delta
+}
+
+// pixelChanged compares colors rather than indices, since the frames can have different palettes
+func pixelChanged(prev, frame *image.Paletted, x, y int) bool {
+	if prev == frame {
+		return false
+	}
+	if !image.Pt(x, y).In(prev.Bounds()) {
+		return true
+	}
+	if len(prev.Palette) > 0 && len(frame.Palette) > 0 && &prev.Palette[0] == &frame.Palette[0] {
+		// same palette, so we can skip looking up the colors
+		return prev.ColorIndexAt(x, y) != frame.ColorIndexAt(x, y)
+	}
+	r1, g1, b1, a1 := prev.At(x, y).RGBA()
+	r2, g2, b2, a2 := frame.At(x, y).RGBA()
+	return r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2
+}
+
+// transparentPalettes gives each palette a transparent color at the end, if it doesn't have one already and
+// there's room for one. Each palette is only extended once, so frames sharing the global palette still match it.
+type transparentPalettes map[*color.Color]transparentPalette
+
+type transparentPalette struct {
+	palette          color.Palette
+	transparentIndex int
+}
+
+// get returns palette with a transparent color, and the index of that color or -1 if the palette is full
+func (palettes transparentPalettes) get(palette color.Palette) (color.Palette, int) {
+	if len(palette) == 0 {
+		return palette, -1
+	}
+	if cached, ok := palettes[&palette[0]]; ok {
+		return cached.palette, cached.transparentIndex
+	}
+	extended := transparentPalette{palette: palette, transparentIndex: -1}
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			extended.transparentIndex = i
+			break
+		}
+	}
+	if extended.transparentIndex == -1 && len(palette) < 256 {
+		extended.palette = append(append(color.Palette{}, palette...), color.Transparent)
+		extended.transparentIndex = len(palette)
+	}
+	palettes[&palette[0]] = extended
+	return extended.palette, extended.transparentIndex
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	palette := color.Palette{color.Black, color.White, color.RGBA{R: 255, A: 255}}
+	bounds := image.Rect(0, 0, 8, 6)
+	first := image.NewPaletted(bounds, palette)
+	second := image.NewPaletted(bounds, palette)
+	second.SetColorIndex(2, 1, 1)
+	second.SetColorIndex(4, 3, 2)
+	anim := &gif.GIF{
+		Image:  []*image.Paletted{first, second, second},
+		Delay:  []int{5, 5, 5},
+		Config: image.Config{ColorModel: palette, Width: bounds.Dx(), Height: bounds.Dy()},
+	}
+
+	t.Run("frames only hold the pixels that changed", func(t *testing.T) {
+		delta := deltaEncode(anim)
+		assert.Equal(t, bounds, delta.Image[0].Bounds())
+		assert.Equal(t, image.Rect(2, 1, 5, 4), delta.Image[1].Bounds())
+		assert.Equal(t, 1, delta.Image[2].Bounds().Dx()*delta.Image[2].Bounds().Dy())
+		assert.Equal(t, []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalNone}, delta.Disposal)
+
+		// the pixels in the box that didn't change are see-through
+		_, _, _, a := delta.Image[1].At(3, 2).RGBA()
+		assert.Equal(t, uint32(0), a)
+		assert.Equal(t, color.White, delta.Image[1].At(2, 1))
+	})
+
+	t.Run("looks the same once decoded", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Nil(t, Encode(&buf, anim))
+		decoded, err := gif.DecodeAll(&buf)
+		assert.Nil(t, err)
+		canvas := image.NewRGBA(bounds)
+		for i, frame := range decoded.Image {
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					assert.Equal(t, color.RGBAModel.Convert(anim.Image[i].At(x, y)), canvas.At(x, y))
+				}
+			}
+		}
+	})
+
+	t.Run("full palettes still get cropped", func(t *testing.T) {
+		fullPalette := make(color.Palette, 256)
+		for i := range fullPalette {
+			fullPalette[i] = color.Gray{Y: uint8(i)}
+		}
+		first := image.NewPaletted(bounds, fullPalette)
+		second := image.NewPaletted(bounds, fullPalette)
+		second.SetColorIndex(6, 5, 200)
+		delta := deltaEncode(&gif.GIF{Image: []*image.Paletted{first, second}, Delay: []int{5, 5}})
+		assert.Equal(t, image.Rect(6, 5, 7, 6), delta.Image[1].Bounds())
+		assert.Len(t, delta.Image[1].Palette, 256)
+	})
+}
+
+func TestCreateGifDeltaEncodes(t *testing.T) {
+	// a red background with a blue pixel that jumps from one corner to the other,
+	// so the box that changes between the second and third frames is mostly unchanged pixels
+	palette := color.Palette{color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	input := &gif.GIF{Delay: []int{5, 5, 5}}
+	for _, blue := range []image.Point{{-1, -1}, {2, 2}, {30, 15}} {
+		frame := image.NewPaletted(image.Rect(0, 0, 40, 20), palette)
+		frame.SetColorIndex(blue.X, blue.Y, 1)
+		input.Image = append(input.Image, frame)
+	}
+	var in bytes.Buffer
+	assert.Nil(t, gif.EncodeAll(&in, input))
+
+	// the default palette size, which used to leave no room for a transparent color
+	opts := DefaultOptions()
+	opts.Target = &Target{Rect: image.Rect(0, 0, 40, 20)}
+	opts.Ditherer = DitherNone
+	var out bytes.Buffer
+	_, err := CreateGif(context.Background(), &in, &out, opts)
+	assert.Nil(t, err)
+	anim, err := gif.DecodeAll(&out)
+	assert.Nil(t, err)
+
+	frame := anim.Image[2]
+	assert.Equal(t, image.Rect(2, 2, 31, 16), frame.Bounds())
+	_, _, _, a := frame.At(10, 10).RGBA()
+	assert.Equal(t, uint32(0), a)
+	_, _, _, a = frame.At(30, 15).RGBA()
+	assert.Equal(t, uint32(0xffff), a)
+}
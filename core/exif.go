@@ -0,0 +1,293 @@
+// reads the bits of EXIF metadata we care about out of images
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+)
+
+const (
+	exifTagOrientation = 0x0112
+	exifTagGPSInfo     = 0x8825
+	// orientation 1 is the right way up, 2 through 8 are flips and rotations
+	orientationNormal = 1
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// the sizes in bytes of each of the TIFF field types, by type number
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifData is the TIFF structure inside a JPEG's EXIF segment. tiff is a slice of the JPEG's bytes,
+// so changes to it change the JPEG.
+type exifData struct {
+	tiff  []byte
+	order binary.ByteOrder
+	ifd0  uint32
+}
+
+type ifdEntry struct {
+	tag, fieldType uint16
+	count          uint32
+	// where the entry's 4 byte value field is in the tiff, which holds the value itself if it fits,
+	// otherwise the offset of the value
+	valueAt uint32
+}
+
+// findExif finds the EXIF segment in a JPEG, returning nil if it's not a JPEG or doesn't have one
+func findExif(data []byte) (*exifData, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, fmt.Errorf("expected a JPEG marker at byte %v", i)
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// padding before a marker
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// the image data starts, or the image ends, without any EXIF
+			return nil, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// markers without a length
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, fmt.Errorf("JPEG segment at byte %v runs past the end of the file", i)
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return parseTiffHeader(segment[len(exifHeader):])
+		}
+		i += 2 + length
+	}
+	return nil, nil
+}
+
+// findPNGExif finds the eXIf chunk in a PNG, returning where its data starts and ends, or 0, 0 if there isn't one.
+// The chunk's checksum is the 4 bytes after end.
+func findPNGExif(data []byte) (int, int, error) {
+	// chunks start after the 8 byte signature, each a length, a type, the data then a checksum
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			return 0, 0, fmt.Errorf("PNG %s chunk at byte %v runs past the end of the file", chunkType, i)
+		}
+		if chunkType == "eXIf" {
+			return i + 8, i + 8 + length, nil
+		}
+		if chunkType == "IEND" {
+			break
+		}
+		i += 12 + length
+	}
+	return 0, 0, nil
+}
+
+// findWebPExif finds the EXIF chunk in a WebP, returning nil if it doesn't have one
+func findWebPExif(data []byte) (*exifData, error) {
+	// RIFF chunks start after the 12 byte header, each a type, a little endian length then the data,
+	// padded to an even length
+	for i := 12; i+8 <= len(data); {
+		chunkType := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if length < 0 || i+8+length > len(data) {
+			return nil, fmt.Errorf("WebP %s chunk at byte %v runs past the end of the file", chunkType, i)
+		}
+		if chunkType == "EXIF" {
+			return parseTiffHeader(data[i+8 : i+8+length])
+		}
+		i += 8 + length + length%2
+	}
+	return nil, nil
+}
+
+func parseTiffHeader(tiff []byte) (*exifData, error) {
+	// PNGs and WebPs should hold the TIFF structure on its own, but some writers keep the JPEG header
+	tiff = bytes.TrimPrefix(tiff, exifHeader)
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("EXIF segment is too short")
+	}
+	exif := &exifData{tiff: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		exif.order = binary.LittleEndian
+	case "MM":
+		exif.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("EXIF segment has an unknown byte order %q", tiff[:2])
+	}
+	if exif.order.Uint16(tiff[2:]) != 42 {
+		return nil, fmt.Errorf("EXIF segment isn't TIFF")
+	}
+	exif.ifd0 = exif.order.Uint32(tiff[4:])
+	return exif, nil
+}
+
+// entries reads the entries of the IFD at offset
+func (exif *exifData) entries(offset uint32) ([]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(exif.tiff)) {
+		return nil, fmt.Errorf("EXIF IFD at %v is out of bounds", offset)
+	}
+	count := uint32(exif.order.Uint16(exif.tiff[offset:]))
+	if uint64(offset)+2+12*uint64(count) > uint64(len(exif.tiff)) {
+		return nil, fmt.Errorf("EXIF IFD at %v runs past the end of the segment", offset)
+	}
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		at := offset + 2 + 12*uint32(i)
+		entries[i] = ifdEntry{
+			tag:       exif.order.Uint16(exif.tiff[at:]),
+			fieldType: exif.order.Uint16(exif.tiff[at+2:]),
+			count:     exif.order.Uint32(exif.tiff[at+4:]),
+			valueAt:   at + 8,
+		}
+	}
+	return entries, nil
+}
+
+// orientation returns the EXIF orientation from 1 to 8, or 1 if it's missing or out of range
+func (exif *exifData) orientation() (int, error) {
+	entries, err := exif.entries(exif.ifd0)
+	if err != nil {
+		return orientationNormal, err
+	}
+	for _, entry := range entries {
+		if entry.tag == exifTagOrientation && entry.fieldType == 3 {
+			orientation := int(exif.order.Uint16(exif.tiff[entry.valueAt:]))
+			if orientation < 1 || orientation > 8 {
+				return orientationNormal, nil
+			}
+			return orientation, nil
+		}
+	}
+	return orientationNormal, nil
+}
+
+// stripGPS zeroes out the GPS IFD, its values and all, leaving it an empty IFD
+func (exif *exifData) stripGPS() error {
+	entries, err := exif.entries(exif.ifd0)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.tag != exifTagGPSInfo {
+			continue
+		}
+		gpsOffset := exif.order.Uint32(exif.tiff[entry.valueAt:])
+		gpsEntries, err := exif.entries(gpsOffset)
+		if err != nil {
+			return err
+		}
+		for _, gpsEntry := range gpsEntries {
+			size := uint64(tiffTypeSizes[gpsEntry.fieldType]) * uint64(gpsEntry.count)
+			if size <= 4 {
+				continue
+			}
+			valueOffset := uint64(exif.order.Uint32(exif.tiff[gpsEntry.valueAt:]))
+			if valueOffset+size > uint64(len(exif.tiff)) {
+				return fmt.Errorf("EXIF GPS value at %v is out of bounds", valueOffset)
+			}
+			zero(exif.tiff[valueOffset : valueOffset+size])
+		}
+		// the entries themselves, then the count so readers see an empty IFD
+		zero(exif.tiff[gpsOffset+2 : gpsOffset+2+12*uint32(len(gpsEntries))])
+		exif.order.PutUint16(exif.tiff[gpsOffset:], 0)
+	}
+	return nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// StripGPS returns a copy of a JPEG, PNG, WebP or TIFF with the location it was taken at wiped from its EXIF
+// metadata. Anything else comes back unchanged, since GIFs and BMPs don't carry EXIF.
+func StripGPS(data []byte) ([]byte, error) {
+	stripped := append([]byte(nil), data...)
+	var exif *exifData
+	var pngStart, pngEnd int
+	var err error
+	switch format, _ := sniffFormat(stripped); format.name {
+	case "jpeg":
+		exif, err = findExif(stripped)
+	case "png":
+		pngStart, pngEnd, err = findPNGExif(stripped)
+		if err == nil && pngEnd > 0 {
+			exif, err = parseTiffHeader(stripped[pngStart:pngEnd])
+		}
+	case "webp":
+		exif, err = findWebPExif(stripped)
+	case "tiff":
+		exif, err = parseTiffHeader(stripped)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("had trouble reading the EXIF metadata: %s", err.Error())
+	}
+	if exif == nil {
+		return stripped, nil
+	}
+	if err = exif.stripGPS(); err != nil {
+		return nil, fmt.Errorf("had trouble stripping the GPS metadata: %s", err.Error())
+	}
+	if pngEnd > 0 {
+		// the checksum covers the chunk type as well as its data
+		binary.BigEndian.PutUint32(stripped[pngEnd:], crc32.ChecksumIEEE(stripped[pngStart-4:pngEnd]))
+	}
+	return stripped, nil
+}
+
+// applyOrientation turns img the right way up given its EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= orientationNormal || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+	dstBounds := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		// 5 through 8 swap the width and height
+		dstBounds = image.Rect(0, 0, h, w)
+	}
+	dst := image.NewRGBA(dstBounds)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flipped horizontally
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				dx, dy = x, h-1-y
+			case 5: // flipped over the top left to bottom right diagonal
+				dx, dy = y, x
+			case 6: // needs turning 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // flipped over the top right to bottom left diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs turning 90° counterclockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// the latitude stored in exifJPEG, 37°46'30"
+var testLatitude = []uint32{37, 1, 46, 1, 30, 1}
+
+// exifJPEG encodes img as a JPEG with an EXIF segment holding orientation and a GPS latitude
+func exifJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	segment := append(append([]byte{}, exifHeader...), exifTiff(orientation)...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(2+len(segment)))
+	app1 = append(app1, segment...)
+
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	jpg := buf.Bytes()
+	return append(append(append([]byte{}, jpg[:2]...), app1...), jpg[2:]...)
+}
+
+// exifPNG encodes img as a PNG with an eXIf chunk like exifJPEG's EXIF segment
+func exifPNG(t *testing.T, img image.Image) []byte {
+	tiff := exifTiff(orientationNormal)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(append(chunk, "eXIf"...), tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, img))
+	encoded := buf.Bytes()
+	// right after the signature and the 25 byte IHDR chunk
+	return append(append(append([]byte{}, encoded[:33]...), chunk...), encoded[33:]...)
+}
+
+// exifWebP is the header of an extended WebP with an EXIF chunk like exifJPEG's EXIF segment, without any image
+func exifWebP() []byte {
+	tiff := exifTiff(orientationNormal)
+	chunks := append([]byte("VP8X"), binary.LittleEndian.AppendUint32(nil, 10)...)
+	// just the flag saying there's EXIF, then a 1x1 canvas
+	chunks = append(chunks, 0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	chunks = append(chunks, "EXIF"...)
+	chunks = binary.LittleEndian.AppendUint32(chunks, uint32(len(tiff)))
+	chunks = append(chunks, tiff...)
+	webp := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunks)))...)
+	return append(append(webp, "WEBP"...), chunks...)
+}
+
+// exifTiff is the TIFF structure of an EXIF segment holding orientation and a GPS latitude
+func exifTiff(orientation uint16) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	// IFD0 at 8, with the GPS IFD right after it at 38
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, ifdEntryBytes(exifTagOrientation, 3, 1, uint32(orientation))...)
+	tiff = append(tiff, ifdEntryBytes(exifTagGPSInfo, 4, 1, 38)...)
+	tiff = le.AppendUint32(tiff, 0)
+	// the GPS IFD, with the latitude's rationals after it at 68
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, ifdEntryBytes(1, 2, 2, uint32('N'))...)
+	tiff = append(tiff, ifdEntryBytes(2, 5, 3, 68)...)
+	tiff = le.AppendUint32(tiff, 0)
+	for _, v := range testLatitude {
+		tiff = le.AppendUint32(tiff, v)
+	}
+	return tiff
+}
+
+func ifdEntryBytes(tag, fieldType uint16, count, value uint32) []byte {
+	le := binary.LittleEndian
+	entry := le.AppendUint16(nil, tag)
+	entry = le.AppendUint16(entry, fieldType)
+	entry = le.AppendUint32(entry, count)
+	return le.AppendUint32(entry, value)
+}
+
+// halfAndHalf is red on the left and blue on the right
+func halfAndHalf() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	t.Run("turns sideways photos upright", func(t *testing.T) {
+		img, err := Decode(bytes.NewReader(exifJPEG(t, halfAndHalf(), 6)))
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 16, 32), img.Bounds())
+		// turned clockwise, the left half ends up on top
+		r, _, b, _ := img.At(8, 4).RGBA()
+		assert.Greater(t, r, b)
+		r, _, b, _ = img.At(8, 28).RGBA()
+		assert.Greater(t, b, r)
+	})
+
+	t.Run("leaves upright photos alone", func(t *testing.T) {
+		img, err := Decode(bytes.NewReader(exifJPEG(t, halfAndHalf(), 1)))
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 32, 16), img.Bounds())
+	})
+}
+
+func TestApplyOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	first, second := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}
+	img.Set(0, 0, first)
+	img.Set(1, 0, second)
+
+	for _, tc := range []struct {
+		orientation       int
+		size              image.Point
+		firstAt, secondAt image.Point
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0), image.Pt(1, 0)},
+		{2, image.Pt(3, 2), image.Pt(2, 0), image.Pt(1, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1), image.Pt(1, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1), image.Pt(1, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0), image.Pt(0, 1)},
+		{6, image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 1)},
+		{7, image.Pt(2, 3), image.Pt(1, 2), image.Pt(1, 1)},
+		{8, image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 1)},
+	} {
+		got := applyOrientation(img, tc.orientation)
+		assert.Equal(t, tc.size, got.Bounds().Size(), "orientation %v", tc.orientation)
+		assert.Equal(t, first, color.RGBAModel.Convert(got.At(tc.firstAt.X, tc.firstAt.Y)), "orientation %v", tc.orientation)
+		assert.Equal(t, second, color.RGBAModel.Convert(got.At(tc.secondAt.X, tc.secondAt.Y)), "orientation %v", tc.orientation)
+	}
+}
+
+func TestStripGPS(t *testing.T) {
+	orig := exifJPEG(t, halfAndHalf(), 6)
+	latitude := make([]byte, 0, 24)
+	for _, v := range testLatitude {
+		latitude = binary.LittleEndian.AppendUint32(latitude, v)
+	}
+
+	t.Run("wipes the location", func(t *testing.T) {
+		stripped, err := StripGPS(orig)
+		assert.Nil(t, err)
+		assert.Len(t, stripped, len(orig))
+		assert.False(t, bytes.Contains(stripped, latitude))
+		assert.True(t, bytes.Contains(orig, latitude))
+
+		exif, err := findExif(stripped)
+		assert.Nil(t, err)
+		gpsEntries, err := exif.entries(38)
+		assert.Nil(t, err)
+		assert.Empty(t, gpsEntries)
+		// and keeps everything else
+		orientation, err := exif.orientation()
+		assert.Nil(t, err)
+		assert.Equal(t, 6, orientation)
+		_, err = Decode(bytes.NewReader(stripped))
+		assert.Nil(t, err)
+	})
+
+	t.Run("wipes the location from PNGs, WebPs and TIFFs too", func(t *testing.T) {
+		for format, orig := range map[string][]byte{
+			"png":  exifPNG(t, halfAndHalf()),
+			"webp": exifWebP(),
+			"tiff": exifTiff(orientationNormal),
+		} {
+			assert.True(t, bytes.Contains(orig, latitude), format)
+			stripped, err := StripGPS(orig)
+			assert.Nil(t, err, format)
+			assert.Len(t, stripped, len(orig), format)
+			assert.False(t, bytes.Contains(stripped, latitude), format)
+		}
+	})
+
+	t.Run("PNGs still decode after being stripped", func(t *testing.T) {
+		stripped, err := StripGPS(exifPNG(t, halfAndHalf()))
+		assert.Nil(t, err)
+		// the PNG decoder checks the checksum of every chunk
+		_, err = Decode(bytes.NewReader(stripped))
+		assert.Nil(t, err)
+	})
+
+	t.Run("anything without EXIF comes back as is", func(t *testing.T) {
+		stripped, err := StripGPS([]byte("not a jpeg"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("not a jpeg"), stripped)
+	})
+}
@@ -1,83 +1,388 @@
-package main
+package core
 
 import (
+	"errors"
+	"fmt"
 	pigo "github.com/esimov/pigo/core"
+	"github.com/jbirms/ok-zoomer/cascade"
 	"image"
 	"io/ioutil"
 	"log"
+	"math"
 	"sort"
+	"sync"
 )
 
-// TODO: make this return a slice of the N best face rects
-func GetBestFaceRect(img image.Image) (image.Rectangle, error) {
-	cascade, err := ioutil.ReadFile("/var/www/prettygood.dev/cascade/facefinder")
-	//cascade, err := ioutil.ReadFile("../cascade/facefinder")
+// ErrNoFaceFound is returned when pigo doesn't detect any faces in an image
+var ErrNoFaceFound = errors.New("no face found in image")
+
+// FaceDetector holds an unpacked pigo cascade so it only has to be loaded once.
+// It's safe to share across goroutines, since running the cascade doesn't modify it.
+type FaceDetector struct {
+	classifier *pigo.Pigo
+	// optional, used to find the pupils in detected faces
+	puploc *pigo.PuplocCascade
+}
+
+// NewFaceDetector unpacks the contents of a pigo facefinder cascade file
+func NewFaceDetector(cascadeFile []byte) (*FaceDetector, error) {
+	// Unpack the binary file. This will return the number of cascade trees,
+	// the tree depth, the threshold and the prediction from tree's leaf nodes.
+	classifier, err := pigo.NewPigo().Unpack(cascadeFile)
 	if err != nil {
-		log.Fatalf("Error reading the cascade file: %v", err)
-		return image.Rectangle{}, err
+		return nil, fmt.Errorf("had trouble unpacking the cascade file: %s", err.Error())
 	}
+	return &FaceDetector{classifier: classifier}, nil
+}
 
-	ngrbaImg := pigo.ImgToNRGBA(img)
-	pixels := pigo.RgbToGrayscale(ngrbaImg)
-	cols, rows := ngrbaImg.Bounds().Max.X, ngrbaImg.Bounds().Max.Y
+// NewFaceDetectorFromFile reads and unpacks the cascade file at cascadePath
+func NewFaceDetectorFromFile(cascadePath string) (*FaceDetector, error) {
+	cascadeFile, err := ioutil.ReadFile(cascadePath)
+	if err != nil {
+		return nil, fmt.Errorf("had trouble reading the cascade file at %s: %s", cascadePath, err.Error())
+	}
+	return NewFaceDetector(cascadeFile)
+}
 
-	// these are the defaults from the pigo README
-	cParams := pigo.CascadeParams{
-		MinSize:     20,
-		MaxSize:     1000,
-		ShiftFactor: 0.1,
-		ScaleFactor: 1.1,
+// AddPuplocCascade unpacks pigo's puploc cascade so pupils can be located in detected faces.
+// Call it before sharing the detector between goroutines.
+func (fd *FaceDetector) AddPuplocCascade(puplocFile []byte) error {
+	puploc, err := pigo.NewPuplocCascade().UnpackCascade(puplocFile)
+	if err != nil {
+		return fmt.Errorf("had trouble unpacking the puploc cascade file: %s", err.Error())
+	}
+	fd.puploc = puploc
+	return nil
+}
 
-		ImageParams: pigo.ImageParams{
-			Pixels: pixels,
-			Rows:   rows,
-			Cols:   cols,
-			Dim:    cols,
-		},
+// AddPuplocCascadeFromFile reads and unpacks the puploc cascade file at puplocPath
+func (fd *FaceDetector) AddPuplocCascadeFromFile(puplocPath string) error {
+	puplocFile, err := ioutil.ReadFile(puplocPath)
+	if err != nil {
+		return fmt.Errorf("had trouble reading the puploc cascade file at %s: %s", puplocPath, err.Error())
 	}
+	return fd.AddPuplocCascade(puplocFile)
+}
 
-	pg := pigo.NewPigo()
-	// Unpack the binary file. This will return the number of cascade trees,
-	// the tree depth, the threshold and the prediction from tree's leaf nodes.
-	classifier, err := pg.Unpack(cascade)
+// NewDefaultFaceDetector uses the copy of cascade/facefinder that's embedded in the binary
+func NewDefaultFaceDetector() (*FaceDetector, error) {
+	return NewFaceDetector(cascade.Facefinder)
+}
+
+var defaultDetector struct {
+	once     sync.Once
+	detector *FaceDetector
+	err      error
+}
+
+// defaultFaceDetector lazily unpacks a single default detector to share
+func defaultFaceDetector() (*FaceDetector, error) {
+	defaultDetector.once.Do(func() {
+		defaultDetector.detector, defaultDetector.err = NewDefaultFaceDetector()
+	})
+	return defaultDetector.detector, defaultDetector.err
+}
+
+// DetectionOptions tunes how pigo scans an image for faces
+type DetectionOptions struct {
+	MinSize     int     // smallest face size to look for, in pixels
+	MaxSize     int     // largest face size to look for, in pixels
+	ShiftFactor float64 // how far the detection window moves between checks, as a fraction of its size
+	ScaleFactor float64 // how much the detection window grows between passes
+	// detections overlapping by more than this intersection over union are clustered into one face
+	IoUThreshold float64
+	// the cascade rotation angles to scan at, where 0.0 is 0 radians and 1.0 is 2*pi radians.
+	// every angle is another full pass over the image, so only add the ones you need
+	Angles []float64
+	// run pupil localization on the returned faces, ignored if the detector has no puploc cascade
+	LocatePupils bool
+	// how faces are ranked against each other, defaults to ScoreAreaWeighted
+	Scorer FaceScorer
+}
+
+// DefaultDetectionOptions returns the defaults from the pigo README, scanning for upright faces only
+func DefaultDetectionOptions() DetectionOptions {
+	return DetectionOptions{
+		MinSize:      20,
+		MaxSize:      1000,
+		ShiftFactor:  0.1,
+		ScaleFactor:  1.1,
+		IoUThreshold: 0.2,
+		Angles:       []float64{0.0},
+	}
+}
+
+// MultiAngleDetectionOptions also scans for faces tilted by 30 degrees either way and turned sideways,
+// which is how they tend to show up in phone photos
+func MultiAngleDetectionOptions() DetectionOptions {
+	opts := DefaultDetectionOptions()
+	opts.Angles = []float64{0.0, 1.0 / 12, 11.0 / 12, 0.25, 0.75}
+	return opts
+}
+
+func (opts DetectionOptions) validate() error {
+	if opts.MinSize < 1 || opts.MaxSize < opts.MinSize {
+		return fmt.Errorf("invalid face size range [%v, %v]", opts.MinSize, opts.MaxSize)
+	}
+	if opts.ShiftFactor <= 0 || opts.ShiftFactor > 1 {
+		return fmt.Errorf("ShiftFactor must be in (0, 1], got %v", opts.ShiftFactor)
+	}
+	if opts.ScaleFactor <= 1 {
+		return fmt.Errorf("ScaleFactor must be greater than 1, got %v", opts.ScaleFactor)
+	}
+	if opts.IoUThreshold < 0 || opts.IoUThreshold > 1 {
+		return fmt.Errorf("IoUThreshold must be in [0, 1], got %v", opts.IoUThreshold)
+	}
+	if len(opts.Angles) == 0 {
+		return fmt.Errorf("need at least one angle to scan at")
+	}
+	return nil
+}
+
+// FaceDetection is a single face found by pigo, along with the score we rank it by
+type FaceDetection struct {
+	Rect   image.Rectangle
+	Center image.Point // pigo's detection center, the center of Rect before clipping
+	Q      float32     // pigo's detection score
+	Scale  int         // pigo's detection scale, the side length of Rect before clipping
+	Score  float64     // the score faces are ranked by, from DetectionOptions.Scorer
+	Angle  float64     // which of DetectionOptions.Angles the face was found at
+	// the pupils, if pupil localization was run and found both of them
+	LeftEye, RightEye *image.Point
+}
+
+// FaceScorer scores a detected face within the image bounds, higher is better
+type FaceScorer func(face FaceDetection, imgBounds image.Rectangle) float64
+
+// ScoreConfidence picks the face pigo is most confident about
+func ScoreConfidence(face FaceDetection, imgBounds image.Rectangle) float64 {
+	return float64(face.Q)
+}
+
+// ScoreAreaWeighted weighs pigo's confidence by the face's area, so big, clear faces win
+func ScoreAreaWeighted(face FaceDetection, imgBounds image.Rectangle) float64 {
+	return float64(face.Q) * float64(face.Rect.Dx()*face.Rect.Dy())
+}
+
+// ScoreLargest picks the biggest face
+func ScoreLargest(face FaceDetection, imgBounds image.Rectangle) float64 {
+	return float64(face.Rect.Dx() * face.Rect.Dy())
+}
+
+// ScoreClosestToCenter picks the face nearest the middle of the image
+func ScoreClosestToCenter(face FaceDetection, imgBounds image.Rectangle) float64 {
+	dx := float64(face.Rect.Min.X+face.Rect.Max.X-imgBounds.Min.X-imgBounds.Max.X) / 2
+	dy := float64(face.Rect.Min.Y+face.Rect.Max.Y-imgBounds.Min.Y-imgBounds.Max.Y) / 2
+	return -math.Hypot(dx, dy)
+}
+
+var faceScorers = map[string]FaceScorer{
+	"confidence": ScoreConfidence,
+	"area":       ScoreAreaWeighted,
+	"largest":    ScoreLargest,
+	"center":     ScoreClosestToCenter,
+}
+
+// FaceScorerByName looks up a scorer by the name users pick it with: confidence, area, largest or center
+func FaceScorerByName(name string) (FaceScorer, error) {
+	scorer, ok := faceScorers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown face scorer %q", name)
+	}
+	return scorer, nil
+}
+
+// Focus is the point a zoom into this face should be centered on, between the eyes if we found them
+func (face FaceDetection) Focus() image.Point {
+	if face.LeftEye != nil && face.RightEye != nil {
+		return face.LeftEye.Add(*face.RightEye).Div(2)
+	}
+	return image.Pt((face.Rect.Min.X+face.Rect.Max.X)/2, (face.Rect.Min.Y+face.Rect.Max.Y)/2)
+}
+
+func (fd *FaceDetector) GetBestFaceRect(img image.Image, opts DetectionOptions) (image.Rectangle, error) {
+	faces, err := fd.GetFaceRects(img, 1, opts)
 	if err != nil {
-		log.Fatalf("Error reading the cascade file: %s", err)
 		return image.Rectangle{}, err
 	}
+	return faces[0].Rect, nil
+}
 
-	angle := 0.0 // cascade rotation angle. 0.0 is 0 radians and 1.0 is 2*pi radians
+// GetFaceRects returns up to n of the faces detected in img, best first. n < 1 returns all of them.
+// If there aren't any, it returns ErrNoFaceFound.
+func (fd *FaceDetector) GetFaceRects(img image.Image, n int, opts DetectionOptions) ([]FaceDetection, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	cParams := pigo.CascadeParams{
+		MinSize:     opts.MinSize,
+		MaxSize:     opts.MaxSize,
+		ShiftFactor: opts.ShiftFactor,
+		ScaleFactor: opts.ScaleFactor,
+
+		ImageParams: imageParams(img),
+	}
 
 	// Run the classifier over the obtained leaf nodes and return the detection results.
 	// The result contains quadruplets representing the row, column, scale and detection score.
-	dets := classifier.RunCascade(cParams, angle)
+	var dets []pigo.Detection
+	var angles []float64 // the angle each of dets was found at
+	for _, angle := range opts.Angles {
+		angleDets := fd.classifier.RunCascade(cParams, angle)
+		dets = append(dets, angleDets...)
+		for range angleDets {
+			angles = append(angles, angle)
+		}
+	}
 
 	// Calculate the intersection over union (IoU) of two clusters.
-	faces := classifier.ClusterDetections(dets, 0.2)
+	// this also merges the same face found at more than one angle
+	faces := fd.classifier.ClusterDetections(dets, opts.IoUThreshold)
 	log.Printf("detected %v faces!", len(faces))
 
-	scoresToFaces := getScoresToFaceRectangles(faces)
-	var scores []float64
-	for k := range scoresToFaces {
-		scores = append(scores, k)
+	scorer := opts.Scorer
+	if scorer == nil {
+		scorer = ScoreAreaWeighted
+	}
+	ranked := rankFaceDetections(faces, img.Bounds(), scorer)
+	for i := range ranked {
+		ranked[i].Angle = detectionAngle(ranked[i], dets, angles, opts.IoUThreshold)
+	}
+	if len(ranked) == 0 {
+		return nil, ErrNoFaceFound
+	}
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	if fd.canLocatePupils(opts) {
+		for i := range ranked {
+			ranked[i].LeftEye, ranked[i].RightEye = fd.locatePupils(ranked[i], cParams.ImageParams)
+		}
+	}
+	return ranked, nil
+}
+
+// imageParams is img in grayscale, the way pigo's cascades want it
+func imageParams(img image.Image) pigo.ImageParams {
+	ngrbaImg := pigo.ImgToNRGBA(img)
+	pixels := pigo.RgbToGrayscale(ngrbaImg)
+	cols, rows := ngrbaImg.Bounds().Max.X, ngrbaImg.Bounds().Max.Y
+	return pigo.ImageParams{
+		Pixels: pixels,
+		Rows:   rows,
+		Cols:   cols,
+		Dim:    cols,
+	}
+}
+
+// canLocatePupils is whether opts asks for pupils and there's a puploc cascade to find them with
+func (fd *FaceDetector) canLocatePupils(opts DetectionOptions) bool {
+	if opts.LocatePupils && fd.puploc == nil {
+		log.Println("asked to locate pupils, but the detector has no puploc cascade, see AddPuplocCascade")
 	}
-	sort.Float64s(scores)
-	return scoresToFaces[scores[len(scores) - 1]], nil
+	return opts.LocatePupils && fd.puploc != nil
 }
 
-func getScoresToFaceRectangles(faceDetections []pigo.Detection) map[float64]image.Rectangle {
-	outMap := make(map[float64]image.Rectangle)
+// addPupils locates the pupils of face, which is in the coordinates of the full size image, in imgParams,
+// the working copy scaled by workingScale that it was found in
+func (fd *FaceDetector) addPupils(imgParams pigo.ImageParams, face *FaceDetection, workingScale float64) {
+	working := scaleFaces([]FaceDetection{*face}, workingScale, image.Rect(0, 0, imgParams.Cols, imgParams.Rows))[0]
+	leftEye, rightEye := fd.locatePupils(working, imgParams)
+	if leftEye == nil || rightEye == nil {
+		return
+	}
+	left, right := scalePoint(*leftEye, 1/workingScale), scalePoint(*rightEye, 1/workingScale)
+	face.LeftEye, face.RightEye = &left, &right
+}
+
+// locatePupils runs the puploc cascade over the regions where the eyes of face should be,
+// returning nil for either pupil that isn't found
+func (fd *FaceDetector) locatePupils(face FaceDetection, imgParams pigo.ImageParams) (*image.Point, *image.Point) {
+	// the eye offsets and perturbation count are the ones from the pigo README
+	// measured on an upright face, so they're turned to match tilted ones
+	locate := func(colOffset float64) *image.Point {
+		offset := rotateOffset(-0.085*float64(face.Scale), colOffset, face.Angle)
+		puploc := pigo.Puploc{
+			Row:      face.Center.Y + offset.Y,
+			Col:      face.Center.X + offset.X,
+			Scale:    float32(face.Scale) * 0.4,
+			Perturbs: 63,
+		}
+		pupil := fd.puploc.RunDetector(puploc, imgParams, face.Angle, false)
+		if pupil == nil || pupil.Row <= 0 || pupil.Col <= 0 {
+			return nil
+		}
+		return &image.Point{X: pupil.Col, Y: pupil.Row}
+	}
+	eyeOffset := 0.185 * float64(face.Scale)
+	leftEye, rightEye := locate(-eyeOffset), locate(eyeOffset)
+	if leftEye != nil && rightEye != nil {
+		log.Printf("found pupils at %s and %s", leftEye, rightEye)
+	}
+	return leftEye, rightEye
+}
+
+// rotateOffset turns an offset of row, col from the center of an upright face to match a face found at angle,
+// the same way pigo turns its cascade, returning it as an x, y offset
+func rotateOffset(row, col, angle float64) image.Point {
+	sin, cos := math.Sincos(angle * 2 * math.Pi)
+	return image.Pt(int(math.Round(sin*row+cos*col)), int(math.Round(cos*row-sin*col)))
+}
+
+// detectionAngle returns the angle of the most confident of dets overlapping face by more than iouThreshold, like
+// the ones clustered into it. Clustering merges the same face found at different angles, so it doesn't say which
+// angle won.
+func detectionAngle(face FaceDetection, dets []pigo.Detection, angles []float64, iouThreshold float64) float64 {
+	best, bestQ := 0.0, float32(math.Inf(-1))
+	for i, det := range dets {
+		rect := image.Rect(det.Col-det.Scale/2, det.Row-det.Scale/2, det.Col+det.Scale/2, det.Row+det.Scale/2)
+		if iou(rect, face.Rect) > iouThreshold && det.Q > bestQ {
+			best, bestQ = angles[i], det.Q
+		}
+	}
+	return best
+}
+
+// rankFaceDetections converts pigo detections into rectangles clipped to imgBounds, sorted best first.
+// Faces that score the same are ordered by confidence, then size, then position, so the ranking is
+// always the same for the same detections.
+func rankFaceDetections(faceDetections []pigo.Detection, imgBounds image.Rectangle, scorer FaceScorer) []FaceDetection {
+	var ranked []FaceDetection
 	for _, face := range faceDetections {
 		rect := image.Rect(
 			face.Col-face.Scale/2,
 			face.Row-face.Scale/2,
 			face.Col+face.Scale/2,
 			face.Row+face.Scale/2,
-		)
+		).Intersect(imgBounds)
+		if rect.Empty() {
+			continue
+		}
 		log.Printf("found a face with dims: %s, score: %v", rect.String(), face.Q)
-		// let's try making score the detection score * area
-		score := float64(face.Q) * float64(rect.Dx() * rect.Dy())
-		outMap[score] = rect
+		detection := FaceDetection{
+			Rect:   rect,
+			Center: image.Pt(face.Col, face.Row),
+			Q:      face.Q,
+			Scale:  face.Scale,
+		}
+		detection.Score = scorer(detection, imgBounds)
+		ranked = append(ranked, detection)
 	}
-	return outMap
-
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Q != b.Q {
+			return a.Q > b.Q
+		}
+		if aArea, bArea := a.Rect.Dx()*a.Rect.Dy(), b.Rect.Dx()*b.Rect.Dy(); aArea != bArea {
+			return aArea > bArea
+		}
+		if a.Rect.Min.Y != b.Rect.Min.Y {
+			return a.Rect.Min.Y < b.Rect.Min.Y
+		}
+		return a.Rect.Min.X < b.Rect.Min.X
+	})
+	return ranked
 }
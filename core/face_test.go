@@ -0,0 +1,110 @@
+package core
+
+import (
+	pigo "github.com/esimov/pigo/core"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestNewDefaultFaceDetector(t *testing.T) {
+	detector, err := NewDefaultFaceDetector()
+	assert.Nil(t, err)
+
+	// a blank image shouldn't have any faces in it
+	faces, err := detector.GetFaceRects(image.NewRGBA(image.Rect(0, 0, 100, 100)), 1, DefaultDetectionOptions())
+	assert.Equal(t, ErrNoFaceFound, err)
+	assert.Empty(t, faces)
+}
+
+func TestNewFaceDetectorFromFile(t *testing.T) {
+	_, err := NewFaceDetectorFromFile("../cascade/facefinder")
+	assert.Nil(t, err)
+
+	_, err = NewFaceDetectorFromFile("../cascade/does-not-exist")
+	assert.NotNil(t, err)
+}
+
+func TestGetFaceRectsValidatesOptions(t *testing.T) {
+	detector, err := NewDefaultFaceDetector()
+	assert.Nil(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+
+	opts := DefaultDetectionOptions()
+	opts.Angles = nil
+	_, err = detector.GetFaceRects(img, 1, opts)
+	assert.NotNil(t, err)
+
+	opts = DefaultDetectionOptions()
+	opts.ScaleFactor = 1.0
+	_, err = detector.GetFaceRects(img, 1, opts)
+	assert.NotNil(t, err)
+
+	_, err = detector.GetFaceRects(img, 1, MultiAngleDetectionOptions())
+	assert.Equal(t, ErrNoFaceFound, err)
+}
+
+func TestFaceDetectionFocus(t *testing.T) {
+	face := FaceDetection{Rect: image.Rect(10, 10, 50, 50)}
+	assert.Equal(t, image.Pt(30, 30), face.Focus())
+
+	face.LeftEye = &image.Point{X: 20, Y: 24}
+	assert.Equal(t, image.Pt(30, 30), face.Focus()) // need both eyes
+
+	face.RightEye = &image.Point{X: 40, Y: 26}
+	assert.Equal(t, image.Pt(30, 25), face.Focus())
+}
+
+func TestRankFaceDetections(t *testing.T) {
+	imgBounds := image.Rect(0, 0, 400, 200)
+	dets := []pigo.Detection{
+		{Row: 100, Col: 200, Scale: 20, Q: 10},  // small and confident, in the middle
+		{Row: 50, Col: 50, Scale: 80, Q: 5},     // big and less confident, in the corner
+		{Row: 150, Col: 350, Scale: 40, Q: 2.5}, // same Q * area as the first face
+		{Row: 150, Col: 300, Scale: 40, Q: 2.5}, // exactly ties with the face above
+	}
+
+	t.Run("area weighted ties are kept and broken deterministically", func(t *testing.T) {
+		got := rankFaceDetections(dets, imgBounds, ScoreAreaWeighted)
+		assert.Len(t, got, 4)
+		assert.Equal(t, image.Pt(50, 50), got[0].Center)
+		// same score, the first face wins on confidence
+		assert.Equal(t, image.Pt(200, 100), got[1].Center)
+		// same score, confidence and size, the leftmost face wins
+		assert.Equal(t, image.Pt(300, 150), got[2].Center)
+		assert.Equal(t, image.Pt(350, 150), got[3].Center)
+	})
+
+	t.Run("pluggable scorers", func(t *testing.T) {
+		assert.Equal(t, image.Pt(200, 100), rankFaceDetections(dets, imgBounds, ScoreConfidence)[0].Center)
+		assert.Equal(t, image.Pt(50, 50), rankFaceDetections(dets, imgBounds, ScoreLargest)[0].Center)
+		assert.Equal(t, image.Pt(200, 100), rankFaceDetections(dets, imgBounds, ScoreClosestToCenter)[0].Center)
+	})
+
+	t.Run("scorers by name", func(t *testing.T) {
+		_, err := FaceScorerByName("largest")
+		assert.Nil(t, err)
+		_, err = FaceScorerByName("prettiest")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRotateOffset(t *testing.T) {
+	// the left eye of an upright face is up and to the left
+	assert.Equal(t, image.Pt(-20, -10), rotateOffset(-10, -20, 0))
+	// turned a quarter of the way around, the same offset ends up the other side of the center
+	assert.Equal(t, image.Pt(-10, 20), rotateOffset(-10, -20, 0.25))
+	assert.Equal(t, image.Pt(20, 10), rotateOffset(-10, -20, 0.5))
+}
+
+func TestDetectionAngle(t *testing.T) {
+	face := FaceDetection{Rect: image.Rect(40, 40, 60, 60)}
+	dets := []pigo.Detection{
+		{Row: 50, Col: 50, Scale: 20, Q: 3},
+		{Row: 51, Col: 49, Scale: 20, Q: 8},
+		// more confident, but someone else's face
+		{Row: 150, Col: 150, Scale: 20, Q: 20},
+	}
+	assert.Equal(t, 0.25, detectionAngle(face, dets, []float64{0, 0.25, 0.75}, 0.2))
+	assert.Equal(t, 0.0, detectionAngle(face, nil, nil, 0.2))
+}
@@ -0,0 +1,117 @@
+// picks something to zoom into when no faces are found
+
+package core
+
+import (
+	"image"
+	"image/color"
+)
+
+// FallbackMode is what CreateGif does when it can't find any faces to zoom into
+type FallbackMode int
+
+const (
+	// FallbackNone gives up and returns ErrNoFaceFound
+	FallbackNone FallbackMode = iota
+	// FallbackCenter zooms into the middle of the image
+	FallbackCenter
+	// FallbackSaliency zooms into the busiest, most detailed part of the image
+	FallbackSaliency
+)
+
+func (mode FallbackMode) String() string {
+	switch mode {
+	case FallbackNone:
+		return "none"
+	case FallbackCenter:
+		return "center"
+	case FallbackSaliency:
+		return "saliency"
+	}
+	return "unknown"
+}
+
+// the image is split into saliencyGridSize x saliencyGridSize cells when looking for its busiest part
+const saliencyGridSize = 16
+
+// fallbackTarget returns a face-sized square to zoom into in place of a face
+func fallbackTarget(img image.Image, mode FallbackMode) image.Rectangle {
+	bounds := img.Bounds()
+	center := image.Pt((bounds.Min.X+bounds.Max.X)/2, (bounds.Min.Y+bounds.Max.Y)/2)
+	if mode == FallbackSaliency {
+		center = getSalientPoint(img)
+	}
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	return squareAround(center, side/4, bounds)
+}
+
+// squareAround returns a square centered on center, shifted as needed to fit inside within
+func squareAround(center image.Point, side int, within image.Rectangle) image.Rectangle {
+	if side < 1 {
+		side = 1
+	}
+	square := image.Rect(center.X-side/2, center.Y-side/2, center.X-side/2+side, center.Y-side/2+side)
+	return shiftInside(square, within)
+}
+
+// getSalientPoint returns the center of the grid cell with the most edge energy,
+// which is a cheap stand-in for where the interesting part of a photo is
+func getSalientPoint(img image.Image) image.Point {
+	bounds := img.Bounds()
+	cellW := bounds.Dx() / saliencyGridSize
+	cellH := bounds.Dy() / saliencyGridSize
+	if cellW < 1 {
+		cellW = 1
+	}
+	if cellH < 1 {
+		cellH = 1
+	}
+	// sample a handful of pixels per cell rather than all of them
+	stride := cellW / 8
+	if cellH < cellW {
+		stride = cellH / 8
+	}
+	if stride < 1 {
+		stride = 1
+	}
+
+	var energy [saliencyGridSize][saliencyGridSize]float64
+	for y := bounds.Min.Y + stride; y < bounds.Max.Y; y += stride {
+		cellY := (y - bounds.Min.Y) / cellH
+		if cellY >= saliencyGridSize {
+			cellY = saliencyGridSize - 1
+		}
+		for x := bounds.Min.X + stride; x < bounds.Max.X; x += stride {
+			cellX := (x - bounds.Min.X) / cellW
+			if cellX >= saliencyGridSize {
+				cellX = saliencyGridSize - 1
+			}
+			lum := luminance(img.At(x, y))
+			energy[cellY][cellX] += abs(lum-luminance(img.At(x-stride, y))) + abs(lum-luminance(img.At(x, y-stride)))
+		}
+	}
+
+	bestX, bestY := saliencyGridSize/2, saliencyGridSize/2
+	for cellY := range energy {
+		for cellX := range energy[cellY] {
+			if energy[cellY][cellX] > energy[bestY][bestX] {
+				bestX, bestY = cellX, cellY
+			}
+		}
+	}
+	return image.Pt(bounds.Min.X+bestX*cellW+cellW/2, bounds.Min.Y+bestY*cellH+cellH/2)
+}
+
+func luminance(c color.Color) float64 {
+	return float64(color.GrayModel.Convert(c).(color.Gray).Y)
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
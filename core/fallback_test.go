@@ -0,0 +1,33 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFallbackTarget(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 400, 200))
+	// draw a checkerboard into the bottom right corner so it's the busiest part of the image
+	for y := 150; y < 200; y++ {
+		for x := 350; x < 400; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	t.Run("center", func(t *testing.T) {
+		got := fallbackTarget(img, FallbackCenter)
+		assert.Equal(t, image.Rect(175, 75, 225, 125), got)
+	})
+
+	t.Run("saliency finds the checkerboard, shifted to stay in bounds", func(t *testing.T) {
+		got := fallbackTarget(img, FallbackSaliency)
+		assert.True(t, image.Pt(375, 175).In(got))
+		assert.True(t, got.In(img.Bounds()))
+		assert.Equal(t, 50, got.Dx())
+		assert.Equal(t, 50, got.Dy())
+	})
+}
@@ -1,165 +1,398 @@
-package main
+// Package core turns photos into gifs that zoom into the faces in them. A gif is made in stages:
+// Decode the image, find faces with a FaceDetector, PlanZoom the crop rect for every frame,
+// Render the frames and Encode them. CreateGif runs the whole pipeline.
+package core
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/esimov/colorquant"
+	"golang.org/x/image/draw"
 	"image"
-	"image/color/palette"
 	"image/gif"
-	_ "image/jpeg"
-	_ "image/png"
+	"io"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 )
 
 const minLoggedDuration = time.Millisecond * 10 // we don't care about logging things that take <.01s
 
-var floydSteinbergDitherer = colorquant.Dither{
-	Filter: [][]float32{
-		{0.0, 0.0, 0.0, 7.0 / 48.0, 5.0 / 48.0},
-		{3.0 / 48.0, 5.0 / 48.0, 7.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0},
-		{1.0 / 48.0, 3.0 / 48.0, 5.0 / 48.0, 3.0 / 48.0, 1.0 / 48.0},
-	},
+func logCheckpointTime(startTime time.Time, checkpoint *time.Duration, eventMsg string) {
+	dur := time.Since(startTime) - *checkpoint
+	if dur > minLoggedDuration {
+		msg := fmt.Sprintf("ran %s in %vs", eventMsg, dur.Seconds())
+		log.Println(msg)
+	}
+	*checkpoint = time.Since(startTime)
 }
 
-func getIntermediateRects(origBounds, faceBounds image.Rectangle, nFrames int) []image.Rectangle {
-	// it's nice to keep everything in floats and convert to int after all the math
-	floatNumFrames := float64(nFrames)
-	var rects []image.Rectangle
-	dx1 := float64(faceBounds.Min.X - origBounds.Min.X) / floatNumFrames
-	dx2 := float64(origBounds.Max.X - faceBounds.Max.X) / floatNumFrames
-	dy1 := float64(faceBounds.Min.Y - origBounds.Min.Y) / floatNumFrames
-	dy2 := float64(origBounds.Max.Y - faceBounds.Max.Y) / floatNumFrames
-	for i := float64(1); i <= floatNumFrames; i++ {
-		rects = append(rects, image.Rect(
-			int(float64(origBounds.Min.X) + i * dx1),
-			int(float64(origBounds.Min.Y) + i * dy1),
-			int(float64(origBounds.Max.X) - i * dx2),
-			int(float64(origBounds.Max.Y) - i * dy2),
-			))
-	}
-	return rects
+// the most frames we'll render per face, to keep a single request from eating the server
+const maxFramesPerFace = 300
+
+// image/gif writes delays and loop counts as 16 bits, so anything bigger wraps around
+const maxGifUint16 = 65535
+
+// Options configures CreateGif. Durations are in 100ths of a second, like gif delays.
+type Options struct {
+	// the detector to find faces with, defaults to one using the embedded cascade
+	Detector  *FaceDetector
+	Detection DetectionOptions
+	NumFrames int // frames spent zooming into and back out of each face
+	NumFaces  int // how many of the best faces to zoom into, one after the other
+	// what to zoom into when there aren't any faces
+	Fallback FallbackMode
+	// zooms into somewhere in particular instead of looking for faces at all
+	Target *Target
+	// how the zoom speeds up and slows down, defaults to EaseLinear
+	Easing Easing
+	// how each frame's crop is scaled up to the output size, nil means draw.CatmullRom
+	Resampler draw.Interpolator
+	// how many colors the palette has, from 2 to 256. Fewer colors make smaller, more retro gifs.
+	// 256 gets 255 colors, since the last one is kept transparent for pixels that don't change between frames.
+	PaletteSize int
+	// lets frames that the global palette fits badly have their own color table, at the cost of a bigger gif
+	LocalPalettes bool
+	// how frames are mapped onto the palette, defaults to DitherJarvisJudiceNinke
+	Ditherer Ditherer
+
+	Delay     int // how long each frame is shown
+	HoldFirst int // extra time the first frame is shown for
+	HoldLast  int // extra time the last frame is shown for
+	// how many times the gif repeats after playing once. 0 loops forever and -1 plays it just once.
+	LoopCount int
+	// the gif is scaled down to fit inside MaxWidth x MaxHeight, 0 means no limit
+	MaxWidth  int
+	MaxHeight int
+	// images bigger than MaxWorkingSize x MaxWorkingSize are scaled down to fit before looking for faces,
+	// 0 means no limit. Detection's MinSize and MaxSize are measured in the scaled down image. It has no
+	// say in how big the gif is, that's MaxWidth and MaxHeight.
+	MaxWorkingSize int
+	// the most bytes the gif can take up, 0 means no limit. Over budget gifs are shrunk and rendered again
+	// until they fit, see Result.Adjustments.
+	MaxBytes int
 }
 
-func panicIfError(err error, panicString string) {
-	if err != nil {
-		panic(panicString + ": " + err.Error())
+// DefaultOptions zooms into the best face over 26 frames, 26 frames was chosen rather arbitrarily.
+// It loops forever with 256 colors, at full size for anything smaller than a 1280x1280 square.
+func DefaultOptions() Options {
+	return Options{
+		Detection:   DefaultDetectionOptions(),
+		NumFrames:   26,
+		NumFaces:    1,
+		Fallback:    FallbackNone,
+		PaletteSize: 256,
+		Delay:       5,
+		// phone photos are 12 megapixels and up, which takes ages to detect faces in and makes gigantic gifs
+		MaxWidth:       1280,
+		MaxHeight:      1280,
+		MaxWorkingSize: 1280,
 	}
 }
 
-func logCheckpointTime(startTime time.Time, checkpoint *time.Duration, eventMsg string) {
-	dur := time.Since(startTime) - *checkpoint
-	if dur > minLoggedDuration {
-		msg := fmt.Sprintf("ran %s in %vs", eventMsg, dur.Seconds())
-		log.Println(msg)
+// Validate returns an error describing the first invalid option
+func (opts Options) Validate() error {
+	if opts.NumFrames < 2 || opts.NumFrames > maxFramesPerFace {
+		return fmt.Errorf("number of frames must be between 2 and %v, got %v", maxFramesPerFace, opts.NumFrames)
 	}
-	*checkpoint = time.Since(startTime)
+	if opts.NumFaces < 1 {
+		return fmt.Errorf("need to zoom into at least one face, got %v", opts.NumFaces)
+	}
+	if opts.Fallback < FallbackNone || opts.Fallback > FallbackSaliency {
+		return fmt.Errorf("unknown fallback mode %v", int(opts.Fallback))
+	}
+	if opts.Target != nil {
+		if err := opts.Target.validate(); err != nil {
+			return err
+		}
+	}
+	if opts.PaletteSize < 2 || opts.PaletteSize > 256 {
+		return fmt.Errorf("palette size must be between 2 and 256, got %v", opts.PaletteSize)
+	}
+	if opts.Delay < 1 || opts.Delay > maxGifUint16 {
+		return fmt.Errorf("delay must be between 1 and %v, got %v", maxGifUint16, opts.Delay)
+	}
+	if opts.HoldFirst < 0 || opts.HoldLast < 0 {
+		return fmt.Errorf("hold durations can't be negative, got %v and %v", opts.HoldFirst, opts.HoldLast)
+	}
+	if opts.Delay+maxInt(opts.HoldFirst, opts.HoldLast) > maxGifUint16 {
+		return fmt.Errorf("a delay of %v plus holds of %v and %v is over the longest a frame can be shown for, %v",
+			opts.Delay, opts.HoldFirst, opts.HoldLast, maxGifUint16)
+	}
+	if opts.LoopCount < -1 || opts.LoopCount > maxGifUint16 {
+		return fmt.Errorf("loop count must be between -1 and %v, got %v", maxGifUint16, opts.LoopCount)
+	}
+	if opts.MaxWidth < 0 || opts.MaxHeight < 0 {
+		return fmt.Errorf("max output size can't be negative, got %vx%v", opts.MaxWidth, opts.MaxHeight)
+	}
+	if opts.MaxWorkingSize != 0 && opts.MaxWorkingSize < minWorkingSize {
+		return fmt.Errorf("max working size must be 0 or at least %v, got %v", minWorkingSize, opts.MaxWorkingSize)
+	}
+	if opts.MaxBytes < 0 {
+		return fmt.Errorf("max bytes can't be negative, got %v", opts.MaxBytes)
+	}
+	return opts.Detection.validate()
 }
 
-func CreateGif(inFile *os.File, numFrames int) string {
+// Result describes a gif made by CreateGif
+type Result struct {
+	Plan      *Plan // the faces zoomed into and the crop rect of every frame
+	Fallback  bool  // whether no faces were found, so the zoom is into the fallback target
+	NumFrames int
+	Width     int
+	Height    int
+	Bytes     int // the size of the encoded gif
+	// what was turned down to fit the gif in Options.MaxBytes, in the order it was done
+	Adjustments []string
+}
 
+// CreateGif reads an image from r and writes a gif to w that zooms into each of the opts.NumFaces best faces
+// in turn, following them around animated GIFs while they play at their own speed. If no faces are found it
+// zooms into the fallback target instead, or returns ErrNoFaceFound for FallbackNone. opts.Target skips looking
+// for faces altogether. ctx is checked between stages. With opts.MaxBytes set, the gif is held in memory until it
+// fits, and ErrOverBudget is returned if it can't be shrunk enough.
+func CreateGif(ctx context.Context, r io.Reader, w io.Writer, opts Options) (result Result, err error) {
+	// a bad image shouldn't be able to take the whole server down with it
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panicked while creating the gif: %v", p)
+		}
+	}()
+	if err = opts.Validate(); err != nil {
+		return result, err
+	}
 	startTime := time.Now()
-	origImg, _, err := image.Decode(inFile)
+
+	planned, err := planGif(ctx, r, opts)
+	if err != nil {
+		return result, err
+	}
+	result.Fallback = planned.fallback
+	result.Plan, err = planned.plan(opts)
 	if err != nil {
-		log.Printf("hit an error: %s", err.Error())
-		panicIfError(err, "had trouble decoding inFile")
+		return result, err
+	}
+	if err = ctx.Err(); err != nil {
+		return result, err
 	}
-	const delay = 5
 
+	// without a budget the gif can go straight to w, otherwise it waits in gifBuf until it fits
+	var gifBuf bytes.Buffer
+	out := &countingWriter{w: w}
+	if opts.MaxBytes > 0 {
+		out.w = &gifBuf
+	}
+	shrinker := budgetShrinker{numSourceFrames: len(planned.sources)}
+	for {
+		outBounds := gifBounds(planned.bounds, opts)
+		result.NumFrames, err = renderGif(ctx, out, planned.sources, planned.workings, planned.workingScale,
+			result.Plan, planned.sourceDelays, outBounds, opts)
+		if err != nil {
+			return result, err
+		}
+		result.Width, result.Height = outBounds.Dx(), outBounds.Dy()
+		result.Bytes = out.n
+		if opts.MaxBytes == 0 || out.n <= opts.MaxBytes {
+			break
+		}
+		adjustment, ok := shrinker.shrink(&opts, outBounds)
+		if !ok {
+			return result, fmt.Errorf("%w: the smallest we could make was %v bytes, over the budget of %v",
+				ErrOverBudget, out.n, opts.MaxBytes)
+		}
+		log.Printf("gif was %v bytes, over the budget of %v, so we %s", out.n, opts.MaxBytes, adjustment)
+		result.Adjustments = append(result.Adjustments, adjustment)
+		gifBuf.Reset()
+		out.n = 0
+		// the frame count might have changed
+		result.Plan, err = planned.plan(opts)
+		if err != nil {
+			return result, err
+		}
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+	}
+	if opts.MaxBytes > 0 {
+		if _, err = gifBuf.WriteTo(w); err != nil {
+			return result, fmt.Errorf("had trouble writing the gif: %s", err.Error())
+		}
+	}
+	log.Printf("finished in %vs", time.Since(startTime).Seconds())
+	return result, nil
+}
+
+// plannedGif is everything CreateGif works out about an image before it plans the zoom and renders it
+type plannedGif struct {
+	sources      []image.Image // every frame of the image, just the one unless it's animated
+	sourceDelays []int
+	bounds       image.Rectangle
+	workings     []image.Image // the scaled down copies of sources
+	workingScale float64
+	// every face found in the image, best first. For animated input it's the best detection of each track.
+	detected []FaceDetection
+	faces    []FaceDetection // the faces to zoom into
+	tracks   []*faceTrack    // the faces to follow around animated input
+	fallback bool            // whether faces is the fallback target, since no faces were found
+}
+
+// planGif decodes r and finds the faces to zoom into, or the target to zoom into instead
+func planGif(ctx context.Context, r io.Reader, opts Options) (*plannedGif, error) {
+	startTime := time.Now()
 	checkpoint := time.Since(startTime)
 
-	origQuantized := image.NewPaletted(origImg.Bounds(), palette.Plan9)
-	floydSteinbergDitherer.Quantize(origImg, origQuantized, 256, true, true)
-	logCheckpointTime(startTime, &checkpoint, "quantization / dithering of input image")
-	anim := gif.GIF{LoopCount: numFrames} // TODO: multiply this by numFaces
-	anim.Image = make([]*image.Paletted, numFrames)
-	anim.Delay = make([]int, numFrames)
-	// put the original image at the start and end
-	anim.Image[0] = origQuantized
-	anim.Image[numFrames - 1] = origQuantized
-	for i := 0; i < numFrames; i++ {
-		anim.Delay[i] = delay
+	detector := opts.Detector
+	if detector == nil {
+		var err error
+		detector, err = defaultFaceDetector()
+		if err != nil {
+			return nil, err
+		}
 	}
+	sources, sourceDelays, err := DecodeFrames(r)
+	if err != nil {
+		return nil, err
+	}
+	logCheckpointTime(startTime, &checkpoint, "decoding")
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	planned := &plannedGif{sources: sources, sourceDelays: sourceDelays, bounds: sources[0].Bounds()}
 
-	bestFaceRect, err := GetBestFaceRect(origImg)
+	planned.workings = make([]image.Image, len(sources))
+	for i, source := range sources {
+		planned.workings[i], planned.workingScale = workingCopy(source, opts.MaxWorkingSize)
+	}
+	logCheckpointTime(startTime, &checkpoint, "downscaling")
+	// faces are found in the working copies, then everything from here on is planned against the original image.
+	// Animations follow each face from frame to frame. Pupils are only looked for once we know which faces
+	// we're zooming into, rather than in every face found.
+	detection := opts.Detection
+	detection.LocatePupils = false
+	if opts.Target != nil {
+		var target image.Rectangle
+		target, err = opts.Target.rect(planned.bounds)
+		planned.faces = []FaceDetection{{Rect: target}}
+		planned.tracks = []*faceTrack{stillTrack(planned.faces[0], len(sources))}
+	} else if len(sources) == 1 {
+		planned.detected, err = detector.GetFaceRects(planned.workings[0], 0, detection)
+		planned.detected = scaleFaces(planned.detected, 1/planned.workingScale, planned.bounds)
+		planned.faces = planned.detected[:minInt(len(planned.detected), opts.NumFaces)]
+	} else {
+		planned.tracks, err = detector.detectTracks(planned.workings, detection, 1/planned.workingScale, planned.bounds)
+		for _, track := range planned.tracks {
+			planned.detected = append(planned.detected, track.best())
+		}
+		planned.tracks = planned.tracks[:minInt(len(planned.tracks), opts.NumFaces)]
+	}
+	if err == nil && opts.Target == nil && detector.canLocatePupils(opts.Detection) {
+		planned.locatePupils(detector)
+	}
 	logCheckpointTime(startTime, &checkpoint, "face detection")
-	panicIfError(err, "had trouble detecting faces in the image")
-	scaledFaceBounds, err := getBoundsWithAspectRatio(origImg.Bounds(), bestFaceRect)
-	panicIfError(err, "had trouble getting scaled bounds")
-	rects := getIntermediateRects(origImg.Bounds(), scaledFaceBounds, numFrames / 2 - 1)
+	if errors.Is(err, ErrNoFaceFound) && opts.Fallback != FallbackNone {
+		log.Printf("no face found, falling back to %s", opts.Fallback)
+		fallback := FaceDetection{Rect: fallbackTarget(planned.workings[0], opts.Fallback)}
+		planned.faces = scaleFaces([]FaceDetection{fallback}, 1/planned.workingScale, planned.bounds)
+		planned.tracks = []*faceTrack{stillTrack(planned.faces[0], len(sources))}
+		planned.fallback = true
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return planned, nil
+}
 
-	checkpoint = time.Since(startTime)
-	wg := new(sync.WaitGroup)
-	cropResults := make(chan CropResult, len(rects))
-	for i, rect := range rects {
-		wg.Add(1)
-		go cropAndResize(&cropResults, wg, i, numFrames, rect, origQuantized)
-	}
-	go func(wg *sync.WaitGroup, results chan CropResult) {
-		wg.Wait()
-		close(results)
-	}(wg, cropResults)
-	for result := range cropResults {
-		for _, index := range result.indices {
-			anim.Image[index] = result.img
+// locatePupils finds the pupils of the faces to zoom into, or of every detection of the faces to follow
+// around animated input
+func (planned *plannedGif) locatePupils(detector *FaceDetector) {
+	if len(planned.sources) == 1 {
+		imgParams := imageParams(planned.workings[0])
+		for i := range planned.faces {
+			detector.addPupils(imgParams, &planned.faces[i], planned.workingScale)
+		}
+		return
+	}
+	for i, working := range planned.workings {
+		imgParams := imageParams(working)
+		for _, track := range planned.tracks {
+			if track.detections[i] != nil {
+				detector.addPupils(imgParams, track.detections[i], planned.workingScale)
+			}
 		}
 	}
-	logCheckpointTime(startTime, &checkpoint, "concurrently created intermediate images")
+	// the focuses are smoothed from the detections, which have moved to between the eyes
+	for _, track := range planned.tracks {
+		track.smooth()
+	}
+}
 
-	outFileName := strings.TrimSuffix(inFile.Name(), filepath.Ext(inFile.Name())) + "_zoom.gif"
-	outFile, err := os.Create(outFileName)
-	panicIfError(err, "had trouble opening outFile")
-	defer outFile.Close()
+// plan plans the zoom. Shrinking the gif to fit the budget can change the options, so it might be planned
+// more than once.
+func (planned *plannedGif) plan(opts Options) (*Plan, error) {
+	if len(planned.sources) > 1 {
+		return planAnimatedZoom(planned.bounds, planned.tracks, len(planned.sources), opts)
+	}
+	return PlanZoom(planned.bounds, planned.faces, opts)
+}
 
-	err = gif.EncodeAll(outFile, &anim)
-	logCheckpointTime(startTime, &checkpoint, "created and encoded gif file at " + outFileName)
-	panicIfError(err, "had trouble encoding outFile as gif")
-	log.Printf("finished in %vs", time.Since(startTime).Seconds())
-	return outFileName
+// gifBounds is the size of the gif made from an image with bounds
+func gifBounds(bounds image.Rectangle, opts Options) image.Rectangle {
+	return outputBounds(bounds, opts.MaxWidth, opts.MaxHeight)
 }
 
-// TODO: make this take a flag for finding the n best faces, and concatting the gifs
-//func main() {
-//	log.Println("starting")
-//	if len(os.Args) != 3 {
-//		panic("usage: gif.go $IN_FILE $NUM_FRAMES")
-//	}
-//	inFile, err := os.Open(os.Args[1])
-//	panicIfError(err, "had trouble opening inFile")
-//	numFrames, err := strconv.Atoi(os.Args[2])
-//	panicIfError(err, "couldn't convert numFrames arg to int")
-//	defer inFile.Close()
-//	CreateGif(inFile, numFrames)
-//}
-
-type CropResult struct {
-	// the indices in the gif in which to place the cropped / resized image
-	indices []int
-	img *image.Paletted
+// renderGif renders plan and encodes it to w, returning how many frames it has
+func renderGif(
+	ctx context.Context,
+	w io.Writer,
+	sources []image.Image,
+	workings []image.Image,
+	workingScale float64,
+	plan *Plan,
+	sourceDelays []int,
+	outBounds image.Rectangle,
+	opts Options) (int, error) {
+	startTime := time.Now()
+	checkpoint := time.Since(startTime)
+	frames, globalPalette, err := render(sources, workings, workingScale, plan, outBounds, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	checkpoint = time.Since(startTime)
+
+	anim := gif.GIF{
+		LoopCount: opts.LoopCount,
+		// frames using the global palette are written without a color table of their own
+		Config: image.Config{ColorModel: globalPalette, Width: outBounds.Dx(), Height: outBounds.Dy()},
+	}
+	anim.Image = frames
+	anim.Delay = frameDelays(plan, sourceDelays, opts)
+	if err = Encode(w, &anim); err != nil {
+		return 0, err
+	}
+	logCheckpointTime(startTime, &checkpoint, "encoding")
+	return len(frames), nil
 }
 
-func cropAndResize(
-	results *chan CropResult,
-	wg *sync.WaitGroup,
-	origIdx,
-	totalFrames int,
-	cropTo image.Rectangle,
-	origImg *image.Paletted) {
-	defer wg.Done()
-	funcStart := time.Now()
-	log.Printf("rect #%v: %s", origIdx, cropTo)
-	croppedImg, err := Crop(origImg, cropTo)
-	checkpoint := time.Since(funcStart)
-	logCheckpointTime(funcStart, &checkpoint, fmt.Sprintf("crop #%v", origIdx))
-	panicIfError(err, "had trouble cropping")
-	resized := Resize(croppedImg, origImg.Bounds())
-	logCheckpointTime(funcStart, &checkpoint, fmt.Sprintf("resize #%v", origIdx))
-	// we already have the full size image at the first and last index
-	reverseIndex := totalFrames - 2 - origIdx
-	*results <- CropResult{indices: []int{origIdx + 1, reverseIndex}, img: resized}
-	log.Printf("ran cropAndResize for img #%v in %vs", origIdx, time.Since(funcStart).Seconds())
+// frameDelays is how long each frame of plan is shown for. Frames of animated input keep the delay of the source
+// frame they're cropped from, so the animation plays at its own speed.
+func frameDelays(plan *Plan, sourceDelays []int, opts Options) []int {
+	delays := make([]int, len(plan.Frames))
+	for i := range delays {
+		delays[i] = opts.Delay
+		// a delay of 0 is left up to the viewer, so it's no use to us
+		if plan.SourceFrames != nil && sourceDelays[plan.SourceFrames[i]] > 0 {
+			delays[i] = sourceDelays[plan.SourceFrames[i]]
+		}
+	}
+	delays[0] += opts.HoldFirst
+	delays[len(delays)-1] += opts.HoldLast
+	// long source delays, or ones summed from dropped frames, can still go over with a hold added
+	for i := range delays {
+		delays[i] = minInt(delays[i], maxGifUint16)
+	}
+	return delays
 }
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// blankPNG is an image without any faces in it
+func blankPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+type panickingResampler struct{}
+
+func (panickingResampler) Transform(draw.Image, f64.Aff3, image.Image, image.Rectangle, draw.Op, *draw.Options) {
+	panic("resampling went wrong")
+}
+
+func (panickingResampler) Scale(draw.Image, image.Rectangle, image.Image, image.Rectangle, draw.Op, *draw.Options) {
+	panic("resampling went wrong")
+}
+
+func TestCreateGif(t *testing.T) {
+	input := blankPNG(t, 120, 80)
+
+	t.Run("no face and no fallback", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader(input), &out, DefaultOptions())
+		assert.Equal(t, ErrNoFaceFound, err)
+		assert.Zero(t, out.Len())
+	})
+
+	t.Run("falls back to the center", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 6
+		opts.Fallback = FallbackCenter
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+		assert.True(t, result.Fallback)
+		assert.Equal(t, 6, result.NumFrames)
+
+		anim, err := gif.DecodeAll(&out)
+		assert.Nil(t, err)
+		assert.Len(t, anim.Image, 6)
+		assert.Equal(t, 0, anim.LoopCount)
+	})
+
+	t.Run("timing, looping and output size", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 6
+		opts.Fallback = FallbackCenter
+		opts.Delay = 4
+		opts.HoldFirst = 10
+		opts.HoldLast = 20
+		opts.LoopCount = 3
+		opts.MaxWidth = 60
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 60, result.Width)
+		assert.Equal(t, 40, result.Height)
+
+		anim, err := gif.DecodeAll(&out)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{14, 4, 4, 4, 4, 24}, anim.Delay)
+		assert.Equal(t, 3, anim.LoopCount)
+		assert.Equal(t, 60, anim.Config.Width)
+		assert.Equal(t, 40, anim.Config.Height)
+		// every frame shares the global color table, whose last color is the transparent one the decoder
+		// fills in for frames that leave pixels unchanged
+		assert.IsType(t, color.Palette{}, anim.Config.ColorModel)
+		global := anim.Config.ColorModel.(color.Palette)
+		for _, frame := range anim.Image {
+			assert.Equal(t, global[:len(global)-1], frame.Palette[:len(frame.Palette)-1])
+		}
+	})
+
+	t.Run("sized by the max width and height rather than the working size", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 6
+		opts.Fallback = FallbackCenter
+		opts.MaxWorkingSize = 64
+		opts.MaxWidth = 0
+		opts.MaxHeight = 0
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 120, result.Width)
+		assert.Equal(t, 80, result.Height)
+
+		opts.MaxHeight = 40
+		out.Reset()
+		result, err = CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 60, result.Width)
+		assert.Equal(t, 40, result.Height)
+		// but still planned against the full size image
+		assert.Equal(t, image.Rect(0, 0, 120, 80), result.Plan.Bounds)
+	})
+
+	t.Run("animated input keeps its timing", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 4
+		opts.Fallback = FallbackCenter
+		opts.Delay = 9
+		opts.HoldLast = 10
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(animatedGif(t, []int{3, 0, 7, 3, 3, 3})), &out, opts)
+		assert.Nil(t, err)
+		assert.True(t, result.Fallback)
+		// enough frames to play the animation through once
+		assert.Equal(t, 6, result.NumFrames)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, result.Plan.SourceFrames)
+
+		anim, err := gif.DecodeAll(&out)
+		assert.Nil(t, err)
+		// frames without a delay of their own get opts.Delay
+		assert.Equal(t, []int{3, 9, 7, 3, 3, 13}, anim.Delay)
+		assert.Equal(t, 40, anim.Config.Width)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 1
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("garbage input is an error, not a panic", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader([]byte("not an image")), &out, DefaultOptions())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("panics while rendering are errors", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 6
+		opts.Fallback = FallbackCenter
+		opts.Ditherer = DitherFunc(func(image.Image, *image.Paletted) { panic("dithering went wrong") })
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Contains(t, err.Error(), "dithering went wrong")
+
+		opts.Ditherer = nil
+		opts.Resampler = panickingResampler{}
+		_, err = CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Contains(t, err.Error(), "resampling went wrong")
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var out bytes.Buffer
+		_, err := CreateGif(ctx, bytes.NewReader(input), &out, DefaultOptions())
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestCreateGifBudget(t *testing.T) {
+	// noise is about the hardest thing there is to compress
+	img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	var input bytes.Buffer
+	assert.Nil(t, png.Encode(&input, img))
+	opts := DefaultOptions()
+	opts.NumFrames = 12
+	opts.Fallback = FallbackCenter
+	opts.Ditherer = DitherBayer8
+
+	var unbudgeted bytes.Buffer
+	full, err := CreateGif(context.Background(), bytes.NewReader(input.Bytes()), &unbudgeted, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, unbudgeted.Len(), full.Bytes)
+	assert.Empty(t, full.Adjustments)
+
+	t.Run("shrinks until it fits", func(t *testing.T) {
+		opts := opts
+		opts.MaxBytes = full.Bytes / 2
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input.Bytes()), &out, opts)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, result.Bytes, opts.MaxBytes)
+		assert.Equal(t, out.Len(), result.Bytes)
+		assert.Equal(t, "turned off dithering", result.Adjustments[0])
+
+		anim, err := gif.DecodeAll(&out)
+		assert.Nil(t, err)
+		assert.Len(t, anim.Image, result.NumFrames)
+		assert.Equal(t, result.Width, anim.Config.Width)
+	})
+
+	t.Run("gives up when it can't fit", func(t *testing.T) {
+		opts := opts
+		opts.MaxBytes = 100
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader(input.Bytes()), &out, opts)
+		assert.True(t, errors.Is(err, ErrOverBudget))
+		assert.Zero(t, out.Len())
+	})
+}
+
+func TestValidateGifLimits(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tweak func(*Options)
+		ok    bool
+	}{
+		"longest delay":        {func(opts *Options) { opts.Delay = 65535 }, true},
+		"delay too long":       {func(opts *Options) { opts.Delay = 70000 }, false},
+		"hold up to the limit": {func(opts *Options) { opts.HoldLast = 65535 - opts.Delay }, true},
+		"hold past the limit":  {func(opts *Options) { opts.HoldFirst = 65535 }, false},
+		"most loops":           {func(opts *Options) { opts.LoopCount = 65535 }, true},
+		"loop count too high":  {func(opts *Options) { opts.LoopCount = 65536 }, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tc.tweak(&opts)
+			assert.Equal(t, tc.ok, opts.Validate() == nil)
+		})
+	}
+
+	t.Run("held source delays are capped too", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.HoldLast = 100
+		plan := &Plan{Frames: make([]RectF, 2), SourceFrames: []int{0, 1}}
+		assert.Equal(t, []int{5, 65535}, frameDelays(plan, []int{0, 65500}, opts))
+	})
+}
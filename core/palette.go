@@ -0,0 +1,151 @@
+// builds gif palettes out of the colors actually in the frames
+
+package core
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// how many pixels are sampled from each frame when building palettes
+const paletteSamplesPerFrame = 4096
+
+// a frame only gets its own color table if it cuts the error against the global palette by at least this much,
+// since every local table costs another 768 bytes
+const localPaletteMinGain = 0.1
+
+// samplePixels picks about n pixels from img on an even grid
+func samplePixels(img image.Image, n int) []color.RGBA {
+	bounds := img.Bounds()
+	stride := int(math.Sqrt(float64(bounds.Dx()*bounds.Dy()) / float64(n)))
+	if stride < 1 {
+		stride = 1
+	}
+	var pixels []color.RGBA
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stride {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stride {
+			pixels = append(pixels, color.RGBAModel.Convert(img.At(x, y)).(color.RGBA))
+		}
+	}
+	return pixels
+}
+
+// cropSampler is what img cropped to cropTo and scaled to fill bounds looks like, going by the nearest pixel,
+// so palettes can be built from a frame's colors without rendering it
+type cropSampler struct {
+	img    image.Image
+	cropTo RectF
+	bounds image.Rectangle
+}
+
+func (crop cropSampler) ColorModel() color.Model {
+	return crop.img.ColorModel()
+}
+
+func (crop cropSampler) Bounds() image.Rectangle {
+	return crop.bounds
+}
+
+func (crop cropSampler) At(x, y int) color.Color {
+	srcX := crop.cropTo.Min.X + (float64(x-crop.bounds.Min.X)+0.5)*crop.cropTo.Dx()/float64(crop.bounds.Dx())
+	srcY := crop.cropTo.Min.Y + (float64(y-crop.bounds.Min.Y)+0.5)*crop.cropTo.Dy()/float64(crop.bounds.Dy())
+	return crop.img.At(int(math.Floor(srcX)), int(math.Floor(srcY)))
+}
+
+// a box of colors to be split by medianCut
+type colorBox []color.RGBA
+
+func channel(c color.RGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	default:
+		return c.B
+	}
+}
+
+// widestChannel returns which of r, g and b spans the largest range in the box, and that range
+func (box colorBox) widestChannel() (int, int) {
+	widest, widestRange := 0, 0
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range box {
+			v := int(channel(c, ch))
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > widestRange {
+			widest, widestRange = ch, hi-lo
+		}
+	}
+	return widest, widestRange
+}
+
+func (box colorBox) average() color.RGBA {
+	var r, g, b int
+	for _, c := range box {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(box)
+	return color.RGBA{R: uint8((r + n/2) / n), G: uint8((g + n/2) / n), B: uint8((b + n/2) / n), A: 255}
+}
+
+// medianCut builds a palette of at most n colors out of pixels. It keeps splitting the box of colors
+// with the widest range down the median of that range, then averages each box into one palette color.
+func medianCut(pixels []color.RGBA, n int) color.Palette {
+	if len(pixels) == 0 {
+		return color.Palette{color.Black}
+	}
+	boxes := []colorBox{append(colorBox(nil), pixels...)}
+	for len(boxes) < n {
+		toSplit, splitChannel, splitRange := -1, 0, 0
+		for i, box := range boxes {
+			ch, r := box.widestChannel()
+			if r > splitRange {
+				toSplit, splitChannel, splitRange = i, ch, r
+			}
+		}
+		if toSplit == -1 {
+			// every box is a single color
+			break
+		}
+		box := boxes[toSplit]
+		sort.Slice(box, func(i, j int) bool {
+			return channel(box[i], splitChannel) < channel(box[j], splitChannel)
+		})
+		median := len(box) / 2
+		boxes[toSplit] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+	palette := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		palette[i] = box.average()
+	}
+	return palette
+}
+
+// paletteError is the mean squared distance from each pixel to its closest color in palette
+func paletteError(pixels []color.RGBA, palette color.Palette) float64 {
+	if len(pixels) == 0 {
+		return 0
+	}
+	var total float64
+	for _, c := range pixels {
+		closest := palette[palette.Index(c)].(color.RGBA)
+		dr := float64(c.R) - float64(closest.R)
+		dg := float64(c.G) - float64(closest.G)
+		db := float64(c.B) - float64(closest.B)
+		total += dr*dr + dg*dg + db*db
+	}
+	return total / float64(len(pixels))
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMedianCut(t *testing.T) {
+	red := color.RGBA{R: 250, G: 10, B: 10, A: 255}
+	darkRed := color.RGBA{R: 230, G: 10, B: 10, A: 255}
+	blue := color.RGBA{R: 10, G: 10, B: 250, A: 255}
+
+	t.Run("splits the widest range first", func(t *testing.T) {
+		got := medianCut([]color.RGBA{red, darkRed, blue, blue}, 2)
+		assert.ElementsMatch(t, color.Palette{color.RGBA{R: 240, G: 10, B: 10, A: 255}, blue}, got)
+	})
+
+	t.Run("stops once every color has its own entry", func(t *testing.T) {
+		got := medianCut([]color.RGBA{red, red, blue, darkRed}, 256)
+		assert.ElementsMatch(t, color.Palette{red, darkRed, blue}, got)
+		assert.Equal(t, 0.0, paletteError([]color.RGBA{red, darkRed, blue}, got))
+	})
+
+	t.Run("nothing to sample", func(t *testing.T) {
+		assert.Len(t, medianCut(nil, 256), 1)
+	})
+}
+
+func TestPaletteError(t *testing.T) {
+	palette := color.Palette{color.RGBA{A: 255}, color.RGBA{R: 100, A: 255}}
+	pixels := []color.RGBA{{R: 10, A: 255}, {R: 100, G: 20, A: 255}}
+	// 10² for the first pixel, 20² for the second
+	assert.Equal(t, 250.0, paletteError(pixels, palette))
+}
+
+func TestSamplePixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	assert.Len(t, samplePixels(img, 100), 100)
+	assert.Len(t, samplePixels(img, 1000000), 10000)
+}
+
+func TestCropSampler(t *testing.T) {
+	// the blue right half, blown up to twice its size
+	crop := cropSampler{img: halfAndHalf(), cropTo: RectF{Min: PointF{16, 0}, Max: PointF{32, 16}}, bounds: image.Rect(0, 0, 32, 32)}
+	for _, c := range samplePixels(crop, 100) {
+		assert.Equal(t, color.RGBA{B: 255, A: 255}, c)
+	}
+	// and the red left edge of the whole image
+	crop.cropTo = RectF{Max: PointF{32, 16}}
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, color.RGBAModel.Convert(crop.At(0, 31)))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, color.RGBAModel.Convert(crop.At(31, 0)))
+}
@@ -0,0 +1,134 @@
+// plans the crop rect for every frame of a zoom
+
+package core
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Plan is the crop rect for every frame of a gif, along with what it zooms into
+type Plan struct {
+	Bounds  image.Rectangle   // the bounds of the whole image
+	Faces   []FaceDetection   // the faces zoomed into, in order
+	Targets []image.Rectangle // the aspect-corrected rect each face is zoomed into
+	Frames  []RectF           // the crop rect for every frame of the gif
+	// for animated input, which source frame each frame of the gif is cropped from
+	SourceFrames []int
+}
+
+// PlanZoom zooms into each of faces in turn, spending opts.NumFrames frames on each face
+func PlanZoom(bounds image.Rectangle, faces []FaceDetection, opts Options) (*Plan, error) {
+	if len(faces) == 0 {
+		return nil, fmt.Errorf("need at least one face to plan a zoom")
+	}
+	plan := &Plan{Bounds: bounds, Faces: faces}
+	for _, face := range faces {
+		scaledFaceBounds, err := getBoundsWithAspectRatio(bounds, face.Rect)
+		if err != nil {
+			return nil, fmt.Errorf("had trouble getting scaled bounds: %s", err.Error())
+		}
+		plan.Targets = append(plan.Targets, centerBoundsOn(bounds, scaledFaceBounds, face.Focus()))
+	}
+	easing := opts.Easing
+	if easing == nil {
+		easing = EaseLinear
+	}
+	plan.Frames = buildTimeline(bounds, plan.Targets, opts.NumFrames, easing)
+	return plan, nil
+}
+
+// zoomRect returns the crop rect t of the way through a zoom from origBounds into target.
+//
+// Magnification is interpolated geometrically, so with linear easing the zoom grows by the same factor
+// every frame instead of crawling at first and rushing at the end. The center moves in step with the
+// size, which keeps the point being zoomed into still on screen rather than sliding across it.
+func zoomRect(origBounds image.Rectangle, target RectF, t float64) RectF {
+	if t == 1 {
+		// land exactly on the target rather than a rounding error away from it
+		return target
+	}
+	origWidth, origHeight := float64(origBounds.Dx()), float64(origBounds.Dy())
+	origCenterX, origCenterY := float64(origBounds.Min.X+origBounds.Max.X)/2, float64(origBounds.Min.Y+origBounds.Max.Y)/2
+	targetCenterX, targetCenterY := (target.Min.X+target.Max.X)/2, (target.Min.Y+target.Max.Y)/2
+	width := math.Max(1, origWidth*math.Pow(target.Dx()/origWidth, t))
+	height := math.Max(1, origHeight*math.Pow(target.Dy()/origHeight, t))
+	// how far the center has moved from the original center towards the target's
+	progress := t
+	if origWidth != target.Dx() {
+		progress = (origWidth - width) / (origWidth - target.Dx())
+	}
+	centerX := origCenterX + progress*(targetCenterX-origCenterX)
+	centerY := origCenterY + progress*(targetCenterY-origCenterY)
+	rect := RectF{
+		Min: PointF{centerX - width/2, centerY - height/2},
+		Max: PointF{centerX + width/2, centerY + height/2},
+	}
+	return shiftInsideF(rect, origBounds)
+}
+
+// segmentProgress returns how far into the zoom each of the frames of a segment is. The segment starts on the
+// original image, zooms in over framesPerFace/2-1 frames, retraces its steps back out, and pads odd frame counts
+// out with the original image.
+func segmentProgress(framesPerFace int, easing Easing) []float64 {
+	zoomIn := framesPerFace/2 - 1
+	progress := make([]float64, framesPerFace)
+	for i := 1; i <= zoomIn; i++ {
+		// t can overshoot past 1, which just keeps zooming in, but there's nothing to zoom out to below 0
+		t := math.Max(0, easing(float64(i)/float64(zoomIn)))
+		progress[i] = t
+		progress[2*zoomIn+1-i] = t
+	}
+	return progress
+}
+
+// buildTimeline lays out one segment per face, zooming from origBounds into the face and back
+// out again over framesPerFace frames, and returns the crop rect for every frame of the gif
+func buildTimeline(origBounds image.Rectangle, faceBounds []image.Rectangle, framesPerFace int, easing Easing) []RectF {
+	var timeline []RectF
+	progress := segmentProgress(framesPerFace, easing)
+	for _, bounds := range faceBounds {
+		for _, t := range progress {
+			timeline = append(timeline, zoomRect(origBounds, toRectF(bounds), t))
+		}
+	}
+	return timeline
+}
+
+// planAnimatedZoom zooms into each of tracks in turn while the animation plays. Each segment is opts.NumFrames
+// long, or the length of the animation if that's longer, looping the animation as needed, and the crop rect
+// follows the face as it moves.
+func planAnimatedZoom(bounds image.Rectangle, tracks []*faceTrack, numSourceFrames int, opts Options) (*Plan, error) {
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("need at least one face to plan a zoom")
+	}
+	easing := opts.Easing
+	if easing == nil {
+		easing = EaseLinear
+	}
+	progress := segmentProgress(maxInt(opts.NumFrames, numSourceFrames), easing)
+	// the frame we're zoomed in the furthest, for reporting the target
+	peak := 0
+	for i, t := range progress {
+		if t > progress[peak] {
+			peak = i
+		}
+	}
+	plan := &Plan{Bounds: bounds}
+	for _, track := range tracks {
+		for i, t := range progress {
+			source := i % numSourceFrames
+			target := aspectTarget(bounds, track.rects[source], track.focuses[source])
+			plan.Frames = append(plan.Frames, zoomRect(bounds, target, t))
+			plan.SourceFrames = append(plan.SourceFrames, source)
+		}
+		peakSource := peak % numSourceFrames
+		peakTarget := aspectTarget(bounds, track.rects[peakSource], track.focuses[peakSource])
+		plan.Faces = append(plan.Faces, track.best())
+		plan.Targets = append(plan.Targets, image.Rect(
+			int(math.Round(peakTarget.Min.X)), int(math.Round(peakTarget.Min.Y)),
+			int(math.Round(peakTarget.Max.X)), int(math.Round(peakTarget.Max.Y))))
+	}
+	return plan, nil
+}
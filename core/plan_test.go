@@ -0,0 +1,157 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	orig := image.Rect(0, 0, 200, 100)
+	face1 := image.Rect(20, 20, 60, 40)
+	face2 := image.Rect(100, 50, 140, 70)
+	origF, face1F, face2F := toRectF(orig), toRectF(face1), toRectF(face2)
+
+	t.Run("single face zooms in and back out", func(t *testing.T) {
+		got := buildTimeline(orig, []image.Rectangle{face1}, 8, EaseLinear)
+		assert.Len(t, got, 8)
+		assert.Equal(t, origF, got[0])
+		assert.Equal(t, origF, got[7])
+		assert.Equal(t, face1F, got[3])
+		// the zoom out mirrors the zoom in
+		for i := 1; i < 7; i++ {
+			assert.Equal(t, got[i], got[7-i])
+		}
+	})
+
+	t.Run("odd frame counts are padded with the original image", func(t *testing.T) {
+		got := buildTimeline(orig, []image.Rectangle{face1}, 9, EaseLinear)
+		assert.Len(t, got, 9)
+		assert.Equal(t, origF, got[7])
+		assert.Equal(t, origF, got[8])
+	})
+
+	t.Run("multiple faces get a segment each", func(t *testing.T) {
+		got := buildTimeline(orig, []image.Rectangle{face1, face2}, 8, EaseLinear)
+		assert.Len(t, got, 16)
+		assert.Equal(t, face1F, got[3])
+		assert.Equal(t, origF, got[8])
+		assert.Equal(t, face2F, got[11])
+	})
+}
+
+func TestPlanZoom(t *testing.T) {
+	orig := image.Rect(0, 0, 200, 100)
+
+	t.Run("needs a face", func(t *testing.T) {
+		_, err := PlanZoom(orig, nil, DefaultOptions())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("targets keep the aspect ratio of the image", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 8
+		plan, err := PlanZoom(orig, []FaceDetection{{Rect: image.Rect(40, 40, 50, 50)}}, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, []image.Rectangle{image.Rect(35, 40, 55, 50)}, plan.Targets)
+		assert.Len(t, plan.Frames, 8)
+		assert.Equal(t, toRectF(plan.Targets[0]), plan.Frames[3])
+	})
+}
+
+// zoomInRects is the n frames zooming in at the start of a segment, after the original image
+func zoomInRects(orig, face image.Rectangle, n int, easing Easing) []RectF {
+	var rects []RectF
+	for _, t := range segmentProgress(2*n+2, easing)[1 : n+1] {
+		rects = append(rects, zoomRect(orig, toRectF(face), t))
+	}
+	return rects
+}
+
+func TestZoomRectEasing(t *testing.T) {
+	orig := image.Rect(0, 0, 200, 100)
+	face := image.Rect(80, 40, 120, 60)
+
+	t.Run("magnification grows by the same factor every frame", func(t *testing.T) {
+		orig := image.Rect(0, 0, 1600, 800)
+		rects := zoomInRects(orig, image.Rect(700, 300, 800, 350), 4, EaseLinear)
+		// 16x zoom over 4 frames is 2x per frame
+		for i, want := range []float64{800, 400, 200, 100} {
+			assert.InDelta(t, want, rects[i].Dx(), 1e-9)
+			assert.InDelta(t, rects[i].Dx(), 2*rects[i].Dy(), 1e-9)
+		}
+	})
+
+	t.Run("rects aren't rounded to whole pixels", func(t *testing.T) {
+		rects := zoomInRects(orig, face, 3, EaseLinear)
+		// 200 * (40/200)^(1/3) isn't a whole number, rounding it is what made the zoom jitter
+		assert.InDelta(t, 116.96, rects[0].Dx(), 0.01)
+		assert.InDelta(t, rects[0].Dx(), 2*rects[0].Dy(), 1e-9)
+	})
+
+	t.Run("ease in starts slower than linear and still lands on the face", func(t *testing.T) {
+		linear := zoomInRects(orig, face, 4, EaseLinear)
+		easeIn := zoomInRects(orig, face, 4, EaseInCubic)
+		assert.Greater(t, easeIn[0].Dx(), linear[0].Dx())
+		assert.Equal(t, toRectF(face), easeIn[3])
+	})
+
+	t.Run("overshooting zooms in past the face and stays in bounds", func(t *testing.T) {
+		rects := zoomInRects(orig, face, 10, EaseOutElastic)
+		overshot := false
+		for _, rect := range rects {
+			assert.True(t, rect.In(orig))
+			assert.Greater(t, rect.Dx(), 0.0)
+			overshot = overshot || rect.Dx() < float64(face.Dx())
+		}
+		assert.True(t, overshot)
+		assert.Equal(t, toRectF(face), rects[9])
+	})
+}
+
+func TestPlanAnimatedZoom(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	// a face walking to the right over 4 frames
+	var detections [][]FaceDetection
+	for i := 0; i < 4; i++ {
+		detections = append(detections, []FaceDetection{{Rect: image.Rect(40+i*5, 40, 60+i*5, 60), Score: 5}})
+	}
+	tracks := trackFaces(detections)
+
+	t.Run("cycles through the source frames", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 10
+		plan, err := planAnimatedZoom(bounds, tracks, 4, opts)
+		assert.Nil(t, err)
+		assert.Len(t, plan.Frames, 10)
+		assert.Equal(t, []int{0, 1, 2, 3, 0, 1, 2, 3, 0, 1}, plan.SourceFrames)
+		assert.Len(t, plan.Faces, 1)
+		assert.Len(t, plan.Targets, 1)
+	})
+
+	t.Run("plays every source frame at least once", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 2
+		plan, err := planAnimatedZoom(bounds, tracks, 4, opts)
+		assert.Nil(t, err)
+		assert.Len(t, plan.Frames, 4)
+	})
+
+	t.Run("follows the face", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.NumFrames = 10
+		plan, err := planAnimatedZoom(bounds, tracks, 4, opts)
+		assert.Nil(t, err)
+		// frames 4 and 5 are both zoomed all the way in, on consecutive source frames
+		assert.Equal(t, plan.Frames[4].Dx(), plan.Frames[5].Dx())
+		assert.Greater(t, plan.Frames[5].Min.X, plan.Frames[4].Min.X)
+		for _, frame := range plan.Frames {
+			assert.True(t, frame.In(bounds))
+		}
+	})
+
+	t.Run("needs a track", func(t *testing.T) {
+		_, err := planAnimatedZoom(bounds, nil, 4, DefaultOptions())
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,95 @@
+// plans a gif without rendering it, for previewing a zoom
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Box is a rect as JSON, in pixels of the decoded image
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func boxOf(rect RectF) Box {
+	return Box{X: rect.Min.X, Y: rect.Min.Y, Width: rect.Dx(), Height: rect.Dy()}
+}
+
+// PreviewFace is a detected face
+type PreviewFace struct {
+	Rect  Box     `json:"rect"`
+	Score float64 `json:"score"` // what faces are ranked by, see DetectionOptions.Scorer
+}
+
+// PreviewTarget is what one segment of the gif zooms into
+type PreviewTarget struct {
+	Face Box `json:"face"` // the face, fallback target or Options.Target
+	// the face grown to the aspect ratio of the image and centered on it, which is where the zoom ends up
+	Bounds Box `json:"bounds"`
+}
+
+// Preview is everything CreateGif would work out about a gif short of rendering it, so a zoom can be shown
+// and tweaked before paying for one. It's meant to be sent as JSON.
+type Preview struct {
+	Width  int `json:"width"` // the size of the decoded image
+	Height int `json:"height"`
+	// the size the gif would be, before any shrinking to fit Options.MaxBytes
+	OutputWidth  int `json:"output_width"`
+	OutputHeight int `json:"output_height"`
+	// every face found, best first, even the ones past Options.NumFaces. Empty with Options.Target set.
+	Faces    []PreviewFace   `json:"faces"`
+	Fallback bool            `json:"fallback"` // whether no faces were found, so the zoom is into the fallback target
+	Targets  []PreviewTarget `json:"targets"`
+	Frames   []Box           `json:"frames"` // the crop rect for every frame of the gif
+	// for animated input, which source frame each frame of the gif is cropped from
+	SourceFrames []int `json:"source_frames,omitempty"`
+}
+
+// PlanGif runs CreateGif up to planning the zoom and describes the gif it would make, without rendering or
+// encoding anything
+func PlanGif(ctx context.Context, r io.Reader, opts Options) (preview *Preview, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panicked while planning the gif: %v", p)
+		}
+	}()
+	if err = opts.Validate(); err != nil {
+		return nil, err
+	}
+	planned, err := planGif(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planned.plan(opts)
+	if err != nil {
+		return nil, err
+	}
+	outBounds := gifBounds(planned.bounds, opts)
+	preview = &Preview{
+		Width:        planned.bounds.Dx(),
+		Height:       planned.bounds.Dy(),
+		OutputWidth:  outBounds.Dx(),
+		OutputHeight: outBounds.Dy(),
+		Faces:        []PreviewFace{},
+		Fallback:     planned.fallback,
+		SourceFrames: plan.SourceFrames,
+	}
+	for _, face := range planned.detected {
+		preview.Faces = append(preview.Faces, PreviewFace{Rect: boxOf(toRectF(face.Rect)), Score: face.Score})
+	}
+	for i, face := range plan.Faces {
+		preview.Targets = append(preview.Targets, PreviewTarget{
+			Face:   boxOf(toRectF(face.Rect)),
+			Bounds: boxOf(toRectF(plan.Targets[i])),
+		})
+	}
+	for _, frame := range plan.Frames {
+		preview.Frames = append(preview.Frames, boxOf(frame))
+	}
+	return preview, nil
+}
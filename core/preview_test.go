@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestPlanGif(t *testing.T) {
+	input := blankPNG(t, 120, 80)
+	opts := DefaultOptions()
+	opts.NumFrames = 6
+	opts.MaxWidth = 60
+
+	t.Run("matches what CreateGif plans", func(t *testing.T) {
+		opts := opts
+		opts.Fallback = FallbackCenter
+		preview, err := PlanGif(context.Background(), bytes.NewReader(input), opts)
+		assert.Nil(t, err)
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+
+		assert.True(t, preview.Fallback)
+		assert.Empty(t, preview.Faces)
+		assert.Equal(t, 120, preview.Width)
+		assert.Equal(t, result.Width, preview.OutputWidth)
+		assert.Equal(t, result.Height, preview.OutputHeight)
+		assert.Len(t, preview.Frames, result.NumFrames)
+		assert.Equal(t, boxOf(result.Plan.Frames[2]), preview.Frames[2])
+		assert.Equal(t, []PreviewTarget{{
+			Face:   boxOf(toRectF(result.Plan.Faces[0].Rect)),
+			Bounds: boxOf(toRectF(result.Plan.Targets[0])),
+		}}, preview.Targets)
+	})
+
+	t.Run("targets and JSON", func(t *testing.T) {
+		opts := opts
+		opts.Target = &Target{Rect: image.Rect(0, 0, 30, 20)}
+		preview, err := PlanGif(context.Background(), bytes.NewReader(input), opts)
+		assert.Nil(t, err)
+		data, err := json.Marshal(preview)
+		assert.Nil(t, err)
+		var got map[string]interface{}
+		assert.Nil(t, json.Unmarshal(data, &got))
+		assert.Equal(t, []interface{}{}, got["faces"])
+		assert.Equal(t, map[string]interface{}{"x": 0.0, "y": 0.0, "width": 30.0, "height": 20.0},
+			got["targets"].([]interface{})[0].(map[string]interface{})["bounds"])
+		assert.Len(t, got["frames"], 6)
+		assert.NotContains(t, got, "source_frames")
+	})
+
+	t.Run("no face", func(t *testing.T) {
+		_, err := PlanGif(context.Background(), bytes.NewReader(input), opts)
+		assert.Equal(t, ErrNoFaceFound, err)
+	})
+}
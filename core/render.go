@@ -0,0 +1,184 @@
+// renders the frames of a planned zoom
+
+package core
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Render draws every frame of plan from img, scaled to outBounds with opts.Resampler. Each frame is resampled from
+// the full color image and only then dithered with opts.Ditherer, so zoomed in frames stay sharp.
+// The colors of every frame go into one global palette of opts.PaletteSize colors, or 255 at most to leave Encode
+// room for a transparent color, which is returned alongside the frames.
+// With opts.LocalPalettes, frames that the global palette fits badly get a palette of their own.
+// Frames zoomed out far enough are resampled from a copy of img scaled down to opts.MaxWorkingSize,
+// rather than the whole of a huge img. Only a few frames are held in full color at once, the rest are
+// dithered as soon as they're rendered.
+func Render(img image.Image, plan *Plan, outBounds image.Rectangle, opts Options) ([]*image.Paletted, color.Palette, error) {
+	working, workingScale := workingCopy(img, opts.MaxWorkingSize)
+	return render([]image.Image{img}, []image.Image{working}, workingScale, plan, outBounds, opts)
+}
+
+// how many frames are rendered at once, each holding a full color copy of the frame while it's dithered
+var renderWorkers = runtime.NumCPU()
+
+// frameKey is what makes a frame unique, its crop rect and the source frame it's cropped from
+type frameKey struct {
+	rect   RectF
+	source int
+}
+
+// render is Render for any number of source frames, picked between by plan.SourceFrames,
+// with the working copy of each already made
+func render(
+	imgs []image.Image,
+	workings []image.Image,
+	workingScale float64,
+	plan *Plan,
+	outBounds image.Rectangle,
+	opts Options) ([]*image.Paletted, color.Palette, error) {
+	if imgs[0].Bounds() != plan.Bounds {
+		return nil, nil, fmt.Errorf("plan is for an image with bounds %s, not %s", plan.Bounds, imgs[0].Bounds())
+	}
+	resampler := opts.Resampler
+	if resampler == nil {
+		resampler = draw.CatmullRom
+	}
+	ditherer := opts.Ditherer
+	if ditherer == nil {
+		ditherer = DitherJarvisJudiceNinke
+	}
+	startTime := time.Now()
+	checkpoint := time.Since(startTime)
+
+	// frames that share a crop rect (the original image at the ends of every segment,
+	// and each zoom level on the way in and back out) only need to be rendered once
+	rectIndices := make(map[frameKey][]int)
+	for i, rect := range plan.Frames {
+		key := frameKey{rect: rect}
+		if plan.SourceFrames != nil {
+			key.source = plan.SourceFrames[i]
+		}
+		if key.source < 0 || key.source >= len(imgs) {
+			return nil, nil, fmt.Errorf("frame %v is cropped from source frame %v, out of %v", i, key.source, len(imgs))
+		}
+		rectIndices[key] = append(rectIndices[key], i)
+	}
+
+	var crops []frameCrop
+	for key, indices := range rectIndices {
+		img, working, rect := imgs[key.source], workings[key.source], key.rect
+		src, srcRect := img, rect
+		// the working copy has all the detail we need as long as we aren't zoomed in past it
+		if working != img && rect.Dx()*workingScale >= float64(outBounds.Dx()) {
+			src, srcRect = working, shiftInsideF(rect.scale(workingScale), working.Bounds())
+			if rect == toRectF(img.Bounds()) {
+				srcRect = toRectF(working.Bounds())
+			}
+		}
+		crops = append(crops, frameCrop{indices: indices, src: src, cropTo: srcRect})
+	}
+
+	// colors that only show up once we're zoomed in, like skin tones and eyes, need a place in the palette too.
+	// they're sampled straight from the source, so no frame has to be held on to until the palette is built.
+	samples := make([][]color.RGBA, len(crops))
+	var allSamples []color.RGBA
+	for i, crop := range crops {
+		samples[i] = samplePixels(cropSampler{img: crop.src, cropTo: crop.cropTo, bounds: outBounds}, paletteSamplesPerFrame)
+		allSamples = append(allSamples, samples[i]...)
+	}
+	paletteSize := minInt(opts.PaletteSize, maxOpaqueColors)
+	globalPalette := medianCut(allSamples, paletteSize)
+	logCheckpointTime(startTime, &checkpoint, "building the global palette")
+
+	frames := make([]*image.Paletted, len(plan.Frames))
+	renderFrame := func(crop frameCrop, samples []color.RGBA) (err error) {
+		// CreateGif's recover only covers its own goroutine, so panics here come back as errors instead
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panicked while rendering frames %v: %v", crop.indices, p)
+			}
+		}()
+		resized, err := cropAndResize(crop.indices, crop.cropTo, crop.src, outBounds, resampler)
+		if err != nil {
+			return err
+		}
+		framePalette := globalPalette
+		if opts.LocalPalettes {
+			localPalette := medianCut(samples, paletteSize)
+			if paletteError(samples, localPalette) < (1-localPaletteMinGain)*paletteError(samples, globalPalette) {
+				framePalette = localPalette
+			}
+		}
+		frame := image.NewPaletted(outBounds, framePalette)
+		ditherer.Dither(resized, frame)
+		for _, index := range crop.indices {
+			frames[index] = frame
+		}
+		return nil
+	}
+
+	// each worker crops and dithers one frame at a time, so there are never more than renderWorkers
+	// full color frames in memory however long the gif is
+	wg := new(sync.WaitGroup)
+	jobs := make(chan int)
+	renderErrs := make(chan error, len(crops))
+	for w := 0; w < minInt(renderWorkers, len(crops)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := renderFrame(crops[i], samples[i]); err != nil {
+					renderErrs <- err
+				}
+			}
+		}()
+	}
+	for i := range crops {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	close(renderErrs)
+	logCheckpointTime(startTime, &checkpoint, "concurrently rendered and dithered frames")
+	if err := <-renderErrs; err != nil {
+		return nil, nil, err
+	}
+	return frames, globalPalette, nil
+}
+
+// frameCrop is a unique frame of the gif, cropped from src
+type frameCrop struct {
+	// the indices in the gif in which to place the cropped / resized image
+	indices []int
+	src     image.Image
+	cropTo  RectF
+}
+
+// cropAndResize crops origImg to cropTo, scaled to fill outBounds
+func cropAndResize(
+	indices []int,
+	cropTo RectF,
+	origImg image.Image,
+	outBounds image.Rectangle,
+	resampler draw.Interpolator) (image.Image, error) {
+	funcStart := time.Now()
+	log.Printf("rect for frames %v: %s", indices, cropTo)
+	// the full size image doesn't need resampling
+	if cropTo == toRectF(origImg.Bounds()) && outBounds == origImg.Bounds() {
+		return origImg, nil
+	}
+	croppedImg, err := Crop(origImg, cropTo, outBounds, resampler)
+	if err != nil {
+		return nil, fmt.Errorf("had trouble cropping: %s", err.Error())
+	}
+	log.Printf("ran cropAndResize for frames %v in %vs", indices, time.Since(funcStart).Seconds())
+	return croppedImg, nil
+}
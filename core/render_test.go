@@ -0,0 +1,56 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRenderLocalPalettes(t *testing.T) {
+	// solid blue on the left, a gradient of reds on the right
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			if x < 32 {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: uint8(100 + 5*(x-32)), A: 255})
+			}
+		}
+	}
+	left := RectF{Max: PointF{32, 32}}
+	right := RectF{Min: PointF{32, 0}, Max: PointF{64, 32}}
+	plan := &Plan{Bounds: img.Bounds(), Frames: []RectF{left, left, left, right}}
+	opts := DefaultOptions()
+	// two colors is one for the blue and one for every red, which fits the red frame badly
+	opts.PaletteSize = 2
+	opts.Ditherer = DitherNone
+	opts.Resampler = draw.NearestNeighbor
+
+	t.Run("frames the global palette fits badly get their own", func(t *testing.T) {
+		opts.LocalPalettes = true
+		frames, global, err := Render(img, plan, image.Rect(0, 0, 32, 32), opts)
+		assert.Nil(t, err)
+		assert.Len(t, frames, 4)
+		for _, frame := range frames[:3] {
+			assert.Equal(t, global, frame.Palette)
+		}
+		assert.NotEqual(t, global, frames[3].Palette)
+		assert.Len(t, frames[3].Palette, 2)
+		for _, c := range frames[3].Palette {
+			r, _, b, _ := c.RGBA()
+			assert.True(t, r > 0 && b == 0, "%v isn't a red", c)
+		}
+	})
+
+	t.Run("every frame keeps the global palette without LocalPalettes", func(t *testing.T) {
+		opts.LocalPalettes = false
+		frames, global, err := Render(img, plan, image.Rect(0, 0, 32, 32), opts)
+		assert.Nil(t, err)
+		for _, frame := range frames {
+			assert.Equal(t, global, frame.Palette)
+		}
+	})
+}
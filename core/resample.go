@@ -0,0 +1,43 @@
+// resampling kernels for scaling the crop of each frame up to the output size
+
+package core
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"math"
+)
+
+// Lanczos3 is the sharpest of the resamplers, at the cost of a little ringing around hard edges
+var Lanczos3 = &draw.Kernel{Support: 3, At: lanczos3}
+
+func lanczos3(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t <= -3 || t >= 3 {
+		return 0
+	}
+	return sinc(t) * sinc(t/3)
+}
+
+func sinc(t float64) float64 {
+	t *= math.Pi
+	return math.Sin(t) / t
+}
+
+var resamplers = map[string]draw.Interpolator{
+	"nearest":    draw.NearestNeighbor,
+	"bilinear":   draw.BiLinear,
+	"catmullrom": draw.CatmullRom,
+	"lanczos":    Lanczos3,
+}
+
+// ResamplerByName looks up a resampler by the name users pick it with, e.g. "lanczos"
+func ResamplerByName(name string) (draw.Interpolator, error) {
+	resampler, ok := resamplers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown resampler %q", name)
+	}
+	return resampler, nil
+}
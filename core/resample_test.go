@@ -0,0 +1,24 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLanczos3(t *testing.T) {
+	assert.Equal(t, 1.0, lanczos3(0))
+	// it passes through zero at every whole pixel so sampling at a pixel gives back that pixel
+	for _, x := range []float64{-2, -1, 1, 2, 3, 4} {
+		assert.InDelta(t, 0, lanczos3(x), 1e-9)
+	}
+	assert.Greater(t, lanczos3(0.5), 0.5)
+	assert.Less(t, lanczos3(1.5), 0.0)
+}
+
+func TestResamplerByName(t *testing.T) {
+	resampler, err := ResamplerByName("lanczos")
+	assert.Nil(t, err)
+	assert.Equal(t, Lanczos3, resampler)
+	_, err = ResamplerByName("blocky")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,50 @@
+// lets the caller pick what to zoom into instead of the face detector
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrTargetOutOfBounds is returned when a Target isn't inside the image
+var ErrTargetOutOfBounds = errors.New("zoom target isn't inside the image")
+
+// Target is somewhere to zoom into in place of the faces CreateGif would find, like a dog or the person the
+// detector passed over. It's in pixels of the decoded image, after any EXIF rotation. Either Rect is set,
+// or Point and Zoom are.
+type Target struct {
+	Rect  image.Rectangle
+	Point image.Point
+	// how far in to zoom on Point, 2 ends up showing half the width and height of the image
+	Zoom float64
+}
+
+func (target *Target) validate() error {
+	if target.Rect.Empty() && target.Zoom < 1 {
+		return fmt.Errorf("zoom target needs a rect or a zoom of at least 1, got %v", target.Zoom)
+	}
+	return nil
+}
+
+// rect returns where to zoom into, grown to the aspect ratio of bounds
+func (target *Target) rect(bounds image.Rectangle) (image.Rectangle, error) {
+	rect := target.Rect
+	if rect.Empty() {
+		if !target.Point.In(bounds) {
+			return rect, fmt.Errorf("%w: %v isn't inside the %vx%v image",
+				ErrTargetOutOfBounds, target.Point, bounds.Dx(), bounds.Dy())
+		}
+		width := maxInt(1, int(float64(bounds.Dx())/target.Zoom))
+		height := maxInt(1, int(float64(bounds.Dy())/target.Zoom))
+		min := target.Point.Sub(image.Pt(width/2, height/2))
+		rect = shiftInside(image.Rectangle{Min: min, Max: min.Add(image.Pt(width, height))}, bounds)
+	}
+	scaled, err := getBoundsWithAspectRatio(bounds, rect)
+	if err != nil {
+		return rect, fmt.Errorf("%w: %v isn't inside the %vx%v image",
+			ErrTargetOutOfBounds, rect, bounds.Dx(), bounds.Dy())
+	}
+	return scaled, nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestTargetRect(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+
+	t.Run("rects are grown to the aspect ratio of the image", func(t *testing.T) {
+		got, err := (&Target{Rect: image.Rect(40, 40, 50, 50)}).rect(bounds)
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(35, 40, 55, 50), got)
+	})
+
+	t.Run("points are zoomed into by the zoom factor", func(t *testing.T) {
+		got, err := (&Target{Point: image.Pt(100, 50), Zoom: 4}).rect(bounds)
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(75, 38, 125, 63), got)
+	})
+
+	t.Run("points near the edge are shifted inside", func(t *testing.T) {
+		got, err := (&Target{Point: image.Pt(0, 0), Zoom: 2}).rect(bounds)
+		assert.Nil(t, err)
+		assert.Equal(t, image.Rect(0, 0, 100, 50), got)
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		_, err := (&Target{Rect: image.Rect(150, 50, 250, 90)}).rect(bounds)
+		assert.True(t, errors.Is(err, ErrTargetOutOfBounds))
+		_, err = (&Target{Point: image.Pt(300, 50), Zoom: 2}).rect(bounds)
+		assert.True(t, errors.Is(err, ErrTargetOutOfBounds))
+	})
+}
+
+func TestCreateGifTarget(t *testing.T) {
+	input := blankPNG(t, 120, 80)
+	opts := DefaultOptions()
+	opts.NumFrames = 6
+	opts.Target = &Target{Rect: image.Rect(0, 0, 30, 20)}
+
+	t.Run("skips face detection", func(t *testing.T) {
+		var out bytes.Buffer
+		result, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.Nil(t, err)
+		assert.False(t, result.Fallback)
+		assert.Equal(t, []image.Rectangle{image.Rect(0, 0, 30, 20)}, result.Plan.Targets)
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		opts := opts
+		opts.Target = &Target{Point: image.Pt(500, 500), Zoom: 2}
+		var out bytes.Buffer
+		_, err := CreateGif(context.Background(), bytes.NewReader(input), &out, opts)
+		assert.True(t, errors.Is(err, ErrTargetOutOfBounds))
+	})
+
+	t.Run("needs a rect or a zoom", func(t *testing.T) {
+		opts := opts
+		opts.Target = &Target{Point: image.Pt(50, 50)}
+		assert.NotNil(t, opts.Validate())
+	})
+}
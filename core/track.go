@@ -0,0 +1,249 @@
+// follows faces from frame to frame of animated input
+
+package core
+
+import (
+	"errors"
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	// the least a face in one frame has to overlap a face in an earlier frame to count as the same face
+	trackIoUThreshold = 0.3
+	// how many frames a face can go missing for, say while someone blinks or turns away, and still be picked back up
+	trackMaxGap = 5
+	// how many frames either side of each frame are averaged together to smooth out a track
+	trackSmoothingRadius = 2
+	// how many faces we look for in each frame, so there's something to pick from when tracks are ranked
+	trackFacesPerFrame = 10
+)
+
+// faceTrack follows one face through every frame of an animation
+type faceTrack struct {
+	// what was detected in each frame, nil where the face wasn't found
+	detections []*FaceDetection
+	// where the face is in every frame, with the gaps filled in and smoothed out
+	rects   []RectF
+	focuses []PointF
+}
+
+// iou is the area of the overlap of a and b over the area of their union
+func iou(a, b image.Rectangle) float64 {
+	overlap := a.Intersect(b)
+	if overlap.Empty() {
+		return 0
+	}
+	overlapArea := float64(overlap.Dx() * overlap.Dy())
+	return overlapArea / (float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - overlapArea)
+}
+
+// lastSeen returns the most recent detection before frame, if it's recent enough to continue the track
+func (track *faceTrack) lastSeen(frame int) *FaceDetection {
+	for i := frame - 1; i >= 0 && i >= frame-1-trackMaxGap; i-- {
+		if track.detections[i] != nil {
+			return track.detections[i]
+		}
+	}
+	return nil
+}
+
+func (track *faceTrack) numDetections() int {
+	n := 0
+	for _, detection := range track.detections {
+		if detection != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// best returns the highest scored detection of the face
+func (track *faceTrack) best() FaceDetection {
+	var best *FaceDetection
+	for _, detection := range track.detections {
+		if detection != nil && (best == nil || detection.Score > best.Score) {
+			best = detection
+		}
+	}
+	return *best
+}
+
+func (track *faceTrack) meanScore() float64 {
+	var total float64
+	for _, detection := range track.detections {
+		if detection != nil {
+			total += detection.Score
+		}
+	}
+	return total / float64(track.numDetections())
+}
+
+// detectTracks finds faces in every frame and strings them together into tracks, best first
+func (fd *FaceDetector) detectTracks(frames []image.Image, opts DetectionOptions, scale float64, bounds image.Rectangle) ([]*faceTrack, error) {
+	detections := make([][]FaceDetection, len(frames))
+	for i, frame := range frames {
+		faces, err := fd.GetFaceRects(frame, trackFacesPerFrame, opts)
+		if err != nil && !errors.Is(err, ErrNoFaceFound) {
+			return nil, err
+		}
+		detections[i] = scaleFaces(faces, scale, bounds)
+	}
+	tracks := trackFaces(detections)
+	if len(tracks) == 0 {
+		return nil, ErrNoFaceFound
+	}
+	return tracks, nil
+}
+
+// trackFaces matches up the faces detected in each frame with the ones in the frames before, best overlap first.
+// Faces that don't match any track start a new one. Tracks are returned with the ones found in the most frames
+// first, then the best scored.
+func trackFaces(detections [][]FaceDetection) []*faceTrack {
+	var tracks []*faceTrack
+	for frame, faces := range detections {
+		type match struct {
+			track *faceTrack
+			face  int
+			iou   float64
+		}
+		var matches []match
+		for _, track := range tracks {
+			last := track.lastSeen(frame)
+			if last == nil {
+				continue
+			}
+			for i, face := range faces {
+				if overlap := iou(last.Rect, face.Rect); overlap >= trackIoUThreshold {
+					matches = append(matches, match{track, i, overlap})
+				}
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].iou > matches[j].iou
+		})
+		matchedTracks := make(map[*faceTrack]bool)
+		matchedFaces := make(map[int]bool)
+		for _, m := range matches {
+			if matchedTracks[m.track] || matchedFaces[m.face] {
+				continue
+			}
+			m.track.detections[frame] = &faces[m.face]
+			matchedTracks[m.track], matchedFaces[m.face] = true, true
+		}
+		for i := range faces {
+			if matchedFaces[i] {
+				continue
+			}
+			track := &faceTrack{detections: make([]*FaceDetection, len(detections))}
+			track.detections[frame] = &faces[i]
+			tracks = append(tracks, track)
+		}
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].numDetections() != tracks[j].numDetections() {
+			return tracks[i].numDetections() > tracks[j].numDetections()
+		}
+		return tracks[i].meanScore() > tracks[j].meanScore()
+	})
+	for _, track := range tracks {
+		track.smooth()
+	}
+	return tracks
+}
+
+// stillTrack is a track of face sitting still for n frames, for zooming into a fallback target
+func stillTrack(face FaceDetection, n int) *faceTrack {
+	track := &faceTrack{detections: make([]*FaceDetection, n)}
+	for i := range track.detections {
+		track.detections[i] = &face
+	}
+	track.smooth()
+	return track
+}
+
+// smooth fills in rects and focuses from the detections. Frames where the face went missing are interpolated
+// from the frames either side, then everything is averaged with its neighbors so the zoom doesn't shake
+// along with the detector.
+func (track *faceTrack) smooth() {
+	n := len(track.detections)
+	rects := make([]RectF, n)
+	focuses := make([]PointF, n)
+	prev := -1
+	for i := 0; i <= n; i++ {
+		if i < n && track.detections[i] == nil {
+			continue
+		}
+		// fill in the gap between the last detection and this one
+		for gap := prev + 1; gap < i; gap++ {
+			switch {
+			case prev == -1 && i == n:
+				// no detections at all, which a track never has
+			case prev == -1:
+				rects[gap], focuses[gap] = detectionGeometry(track.detections[i])
+			case i == n:
+				rects[gap], focuses[gap] = detectionGeometry(track.detections[prev])
+			default:
+				t := float64(gap-prev) / float64(i-prev)
+				prevRect, prevFocus := detectionGeometry(track.detections[prev])
+				nextRect, nextFocus := detectionGeometry(track.detections[i])
+				rects[gap] = lerpRectF(prevRect, nextRect, t)
+				focuses[gap] = lerpPointF(prevFocus, nextFocus, t)
+			}
+		}
+		if i < n {
+			rects[i], focuses[i] = detectionGeometry(track.detections[i])
+			prev = i
+		}
+	}
+
+	track.rects = make([]RectF, n)
+	track.focuses = make([]PointF, n)
+	for i := range rects {
+		lo, hi := maxInt(0, i-trackSmoothingRadius), minInt(n-1, i+trackSmoothingRadius)
+		var rect RectF
+		var focus PointF
+		for j := lo; j <= hi; j++ {
+			rect = RectF{
+				Min: PointF{rect.Min.X + rects[j].Min.X, rect.Min.Y + rects[j].Min.Y},
+				Max: PointF{rect.Max.X + rects[j].Max.X, rect.Max.Y + rects[j].Max.Y},
+			}
+			focus = PointF{focus.X + focuses[j].X, focus.Y + focuses[j].Y}
+		}
+		count := float64(hi - lo + 1)
+		track.rects[i] = rect.scale(1 / count)
+		track.focuses[i] = PointF{focus.X / count, focus.Y / count}
+	}
+}
+
+func detectionGeometry(face *FaceDetection) (RectF, PointF) {
+	focus := face.Focus()
+	return toRectF(face.Rect), PointF{float64(focus.X), float64(focus.Y)}
+}
+
+func lerpPointF(a, b PointF, t float64) PointF {
+	return PointF{a.X + t*(b.X-a.X), a.Y + t*(b.Y-a.Y)}
+}
+
+func lerpRectF(a, b RectF, t float64) RectF {
+	return RectF{Min: lerpPointF(a.Min, b.Min, t), Max: lerpPointF(a.Max, b.Max, t)}
+}
+
+// aspectTarget grows face out to the aspect ratio of bounds, centered on focus and kept inside bounds,
+// like getBoundsWithAspectRatio and centerBoundsOn do for still images but without rounding to whole pixels
+func aspectTarget(bounds image.Rectangle, face RectF, focus PointF) RectF {
+	aspectRatio := float64(bounds.Dy()) / float64(bounds.Dx())
+	width, height := face.Dx(), face.Dy()
+	if height/width > aspectRatio {
+		width = height / aspectRatio
+	} else {
+		height = width * aspectRatio
+	}
+	width, height = math.Min(width, float64(bounds.Dx())), math.Min(height, float64(bounds.Dy()))
+	return shiftInsideF(RectF{
+		Min: PointF{focus.X - width/2, focus.Y - height/2},
+		Max: PointF{focus.X + width/2, focus.Y + height/2},
+	}, bounds)
+}
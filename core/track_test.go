@@ -0,0 +1,65 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestIoU(t *testing.T) {
+	a := image.Rect(0, 0, 10, 10)
+	assert.Equal(t, 1.0, iou(a, a))
+	assert.Equal(t, 0.0, iou(a, image.Rect(20, 20, 30, 30)))
+	// 50 in common out of 150 covered
+	assert.InDelta(t, 1.0/3, iou(a, image.Rect(5, 0, 15, 10)), 1e-9)
+}
+
+func TestTrackFaces(t *testing.T) {
+	face := func(x, y int, score float64) FaceDetection {
+		return FaceDetection{Rect: image.Rect(x, y, x+20, y+20), Score: score}
+	}
+
+	t.Run("follows a moving face across a gap", func(t *testing.T) {
+		tracks := trackFaces([][]FaceDetection{
+			{face(0, 0, 5)},
+			{face(4, 0, 5)},
+			nil,
+			{face(12, 0, 5)},
+			{face(16, 0, 5)},
+			{face(20, 0, 5)},
+		})
+		assert.Len(t, tracks, 1)
+		assert.Equal(t, 5, tracks[0].numDetections())
+		assert.Len(t, tracks[0].rects, 6)
+		// the missing frame is filled in between its neighbors
+		assert.Greater(t, tracks[0].rects[2].Min.X, tracks[0].rects[1].Min.X)
+		assert.Less(t, tracks[0].rects[2].Min.X, tracks[0].rects[3].Min.X)
+	})
+
+	t.Run("smooths out jitter", func(t *testing.T) {
+		tracks := trackFaces([][]FaceDetection{
+			{face(0, 0, 5)}, {face(0, 0, 5)}, {face(6, 0, 5)}, {face(0, 0, 5)}, {face(0, 0, 5)},
+		})
+		assert.Len(t, tracks, 1)
+		assert.Less(t, tracks[0].rects[2].Min.X, 6.0)
+		assert.Greater(t, tracks[0].rects[2].Min.X, 0.0)
+	})
+
+	t.Run("faces far apart get tracks of their own, ranked by how often they're seen", func(t *testing.T) {
+		tracks := trackFaces([][]FaceDetection{
+			{face(0, 0, 9), face(100, 100, 1)},
+			{face(100, 100, 1)},
+			{face(100, 100, 1)},
+		})
+		assert.Len(t, tracks, 2)
+		assert.Equal(t, image.Rect(100, 100, 120, 120), tracks[0].best().Rect)
+		assert.Equal(t, image.Rect(0, 0, 20, 20), tracks[1].best().Rect)
+	})
+
+	t.Run("faces seen as often are ranked by score", func(t *testing.T) {
+		tracks := trackFaces([][]FaceDetection{
+			{face(0, 0, 1), face(100, 100, 9)},
+		})
+		assert.Equal(t, 9.0, tracks[0].best().Score)
+	})
+}
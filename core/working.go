@@ -0,0 +1,60 @@
+// scales huge inputs down to a size that's quick to work with
+
+package core
+
+import (
+	"golang.org/x/image/draw"
+	"image"
+	"math"
+)
+
+// the smallest Options.MaxWorkingSize we'll accept, below that there's not much face left to find
+const minWorkingSize = 64
+
+// workingCopy scales img down to fit inside maxSide x maxSide, returning it along with how much it was scaled by.
+// Images that already fit, or any image when maxSide is 0, come back as they are with a scale of 1.
+func workingCopy(img image.Image, maxSide int) (image.Image, float64) {
+	bounds := img.Bounds()
+	scaledBounds := outputBounds(bounds, maxSide, maxSide)
+	if scaledBounds == bounds {
+		return img, 1
+	}
+	working := image.NewRGBA(scaledBounds)
+	draw.BiLinear.Scale(working, scaledBounds, img, bounds, draw.Src, nil)
+	return working, float64(scaledBounds.Dx()) / float64(bounds.Dx())
+}
+
+func scalePoint(p image.Point, scale float64) image.Point {
+	return image.Pt(int(math.Round(float64(p.X)*scale)), int(math.Round(float64(p.Y)*scale)))
+}
+
+func scaleRect(rect image.Rectangle, scale float64) image.Rectangle {
+	return image.Rectangle{Min: scalePoint(rect.Min, scale), Max: scalePoint(rect.Max, scale)}
+}
+
+func (rect RectF) scale(scale float64) RectF {
+	return RectF{
+		Min: PointF{rect.Min.X * scale, rect.Min.Y * scale},
+		Max: PointF{rect.Max.X * scale, rect.Max.Y * scale},
+	}
+}
+
+// scaleFaces maps faces found in a working copy back onto the image it was scaled from,
+// scale being how much bigger that image is, and clips them to its bounds
+func scaleFaces(faces []FaceDetection, scale float64, bounds image.Rectangle) []FaceDetection {
+	if scale == 1 {
+		return faces
+	}
+	scaled := make([]FaceDetection, len(faces))
+	for i, face := range faces {
+		scaled[i] = face
+		scaled[i].Rect = scaleRect(face.Rect, scale).Intersect(bounds)
+		scaled[i].Center = scalePoint(face.Center, scale)
+		scaled[i].Scale = int(math.Round(float64(face.Scale) * scale))
+		if face.LeftEye != nil && face.RightEye != nil {
+			leftEye, rightEye := scalePoint(*face.LeftEye, scale), scalePoint(*face.RightEye, scale)
+			scaled[i].LeftEye, scaled[i].RightEye = &leftEye, &rightEye
+		}
+	}
+	return scaled
+}
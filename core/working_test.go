@@ -0,0 +1,42 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"testing"
+)
+
+func TestWorkingCopy(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	t.Run("scales big images down", func(t *testing.T) {
+		working, scale := workingCopy(img, 100)
+		assert.Equal(t, image.Rect(0, 0, 100, 50), working.Bounds())
+		assert.Equal(t, 0.25, scale)
+	})
+
+	t.Run("leaves images that fit alone", func(t *testing.T) {
+		working, scale := workingCopy(img, 400)
+		assert.Equal(t, img, working)
+		assert.Equal(t, 1.0, scale)
+		working, _ = workingCopy(img, 0)
+		assert.Equal(t, img, working)
+	})
+}
+
+func TestScaleFaces(t *testing.T) {
+	leftEye, rightEye := image.Pt(12, 15), image.Pt(18, 15)
+	faces := []FaceDetection{
+		{Rect: image.Rect(10, 10, 20, 20), Center: image.Pt(15, 15), Scale: 10, LeftEye: &leftEye, RightEye: &rightEye},
+		{Rect: image.Rect(90, 40, 100, 50), Center: image.Pt(95, 45), Scale: 10},
+	}
+	got := scaleFaces(faces, 4, image.Rect(0, 0, 380, 200))
+	assert.Equal(t, image.Rect(40, 40, 80, 80), got[0].Rect)
+	assert.Equal(t, image.Pt(60, 60), got[0].Center)
+	assert.Equal(t, 40, got[0].Scale)
+	assert.Equal(t, image.Pt(48, 60), *got[0].LeftEye)
+	// clipped to the bounds of the original image
+	assert.Equal(t, image.Rect(360, 160, 380, 200), got[1].Rect)
+	// the faces found in the working copy are left alone
+	assert.Equal(t, image.Rect(10, 10, 20, 20), faces[0].Rect)
+}
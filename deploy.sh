@@ -1,3 +1,3 @@
 #!/bin/sh
-GOOS=linux GOARCH=amd64 go build -o app core/*.go
-#CGO_ENABLED=1 xgo --targets=linux/amd64 --out app github.com/jbirms/ok-zoomer/core
+GOOS=linux GOARCH=amd64 go build -o app ./cmd/ok-zoomer
+#CGO_ENABLED=1 xgo --targets=linux/amd64 --out app github.com/jbirms/ok-zoomer/cmd/ok-zoomer
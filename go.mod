@@ -0,0 +1,20 @@
+module github.com/jbirms/ok-zoomer
+
+go 1.23.0
+
+require (
+	github.com/aws/aws-lambda-go v1.34.1
+	github.com/aws/aws-sdk-go v1.55.8
+	github.com/esimov/colorquant v1.0.0
+	github.com/esimov/pigo v1.4.6
+	github.com/google/uuid v1.3.0
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/image v0.25.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
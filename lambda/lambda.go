@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/jbirms/ok-zoomer/core"
 	"log"
 )
 
@@ -12,26 +16,72 @@ type myReturn struct {
 	Response string `json:"response"`
 }
 
-func handle(ctx context.Context, name events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	log.Print("Request body: ", name)
-	log.Print("context ", ctx)
-	headers := map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept"}
+// unpacked once per lambda container rather than once per request
+var detector *core.FaceDetector
 
-	code := 200
-	response, err := json.Marshal(myReturn{Response:"Hello, " + name.Body})
+var headers = map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept"}
+
+func errorResponse(code int, msg string) (events.APIGatewayProxyResponse, error) {
+	response, err := json.Marshal(myReturn{Response: msg})
 	if err != nil {
 		log.Println(err)
 		response = []byte("Internal Server Error")
 		code = 500
 	}
-
-	return events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
 		StatusCode: code,
-		Headers: headers,
-		Body: string(response),
+		Headers:    headers,
+		Body:       string(response),
+	}, nil
+}
+
+// handle turns the image in the request body into a zoomed gif
+func handle(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return errorResponse(400, "couldn't decode the request body")
+		}
+	}
+
+	opts := core.DefaultOptions()
+	opts.Detector = detector
+	var gifBuf bytes.Buffer
+	_, err := core.CreateGif(ctx, bytes.NewReader(body), &gifBuf, opts)
+	if errors.Is(err, core.ErrNoFaceFound) {
+		return errorResponse(422, err.Error())
+	}
+	var unsupported *core.UnsupportedFormatError
+	if errors.As(err, &unsupported) {
+		return errorResponse(415, err.Error())
+	}
+	if errors.Is(err, core.ErrTooManyPixels) {
+		return errorResponse(413, err.Error())
+	}
+	if err != nil {
+		log.Println(err)
+		return errorResponse(500, "had trouble creating the gif")
+	}
+
+	gifHeaders := map[string]string{"Content-Type": "image/gif"}
+	for k, v := range headers {
+		gifHeaders[k] = v
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode:      200,
+		Headers:         gifHeaders,
+		Body:            base64.StdEncoding.EncodeToString(gifBuf.Bytes()),
+		IsBase64Encoded: true,
 	}, nil
 }
 
 func main() {
+	var err error
+	detector, err = core.NewDefaultFaceDetector()
+	if err != nil {
+		log.Fatalf("had trouble loading the face detector: %s", err.Error())
+	}
 	lambda.Start(handle)
 }